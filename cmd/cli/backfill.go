@@ -0,0 +1,31 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+	"context"
+)
+
+// runBackfill retries the resolution of the stored unresolved points.
+// With an interval it keeps doing so periodically, otherwise it's a
+// single pass.
+func runBackfill(ctx context.Context, e *env) error {
+	interval := e.k.Duration(`interval`)
+	batch := e.k.Int(`batch`)
+
+	for {
+		surveys, resolved, err := e.ingestor.Backfill(ctx, batch)
+		if err != nil {
+			fmt.Printf("Backfill error: %v\n", err)
+		}
+		fmt.Printf("Backfill: surveys:%d resolved points:%d\n", surveys, resolved)
+		if interval <= 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
@@ -3,80 +3,146 @@ package cli
 import (
 	"os"
 	"fmt"
+	"context"
+	"log/slog"
+	"net/http"
 	"github.com/knadh/koanf/v2"
 
-	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/google"
 	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/config"
 	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/db"
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/edsm"
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/cassette"
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/google"
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/ingest"
 	ds "github.com/gczuczy/dw-stellar-density-analyzer/pkg/densitysurvey"
 )
 
+// env is what the commands are working with
+type env struct {
+	k *koanf.Koanf
+	cfg *config.Config
+	cassette *cassette.Cassette
+	ingestor *ingest.Ingestor
+	// the positional arguments after the command
+	args []string
+}
+
+// the commands of the cli, the first positional argument selects them
+var commands = map[string]func(context.Context, *env) error{
+	"ingest": runIngest,
+	"backfill": runBackfill,
+	"reparse": runReparse,
+	"diff": runDiff,
+	"retracted": runRetracted,
+	"restore": runRestore,
+	"purge": runPurge,
+	"lint": runLint,
+}
+
+// the commands working without the database
+var offline = map[string]bool{
+	"lint": true,
+}
+
 func Run() {
 	var cfg *config.Config
 
+	ctx := context.Background()
 	k := koanf.New(".")
 
-	err := parseArgs(k)
+	args, err := parseArgs(k)
 	if err != nil {
 		fmt.Printf("err: %v\n", err)
 		os.Exit(1)
 	}
 
+	command := "ingest"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	cmdf, ok := commands[command]
+	if !ok {
+		fmt.Printf("err: unknown command %s\n", command)
+		os.Exit(1)
+	}
+
 	if cfg, err = config.ParseConfig(k); err != nil {
 		fmt.Printf("err: %v\n", err)
 		os.Exit(1)
 	}
 
-	if err = db.Init(&cfg.DB); err != nil {
+	rec, err := cassette.New(&cfg.Cassette)
+	if err != nil {
 		fmt.Printf("err: %v\n", err)
 		os.Exit(1)
 	}
 
-	creds := k.String(`sa-creds`)
-	ss, err := google.NewSheets(creds)
+	ss, err := google.NewSheets(ctx, &cfg.Google, rec, slog.Default())
 	if err != nil {
-		fmt.Printf("Credentials error: %s", creds)
-		return
+		fmt.Printf("Google auth error (%s): %v\n", cfg.Google.Auth, err)
+		os.Exit(1)
 	}
 
-	entry, err := ds.NewEntrySheet(k.String(`sheetid`), ss)
-	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return
+	// systems already known from earlier surveys first, then EDSM
+	var (
+		pool *db.DBPool
+		store ingest.Store
+		resolver = ds.ResolverChain{}
+	)
+	if !offline[command] {
+		if pool, err = db.New(ctx, &cfg.DB); err != nil {
+			fmt.Printf("err: %v\n", err)
+			os.Exit(1)
+		}
+		defer pool.Close()
+		store = pool
+		resolver = append(resolver, pool)
 	}
+	resolver = append(resolver, ds.NewEDSMResolver(edsm.NewWithClient(rec.Client("edsm", &http.Client{}))))
 
-	ids, err := entry.GetSheetIDs()
-	if err != nil && len(ids) == 0 {
-		fmt.Printf("Error: %v\n", err)
-		return
+	in, err := ingest.New(ss, resolver, store, nil, cfg)
+	if err != nil {
+		fmt.Printf("err: %v\n", err)
+		os.Exit(1)
 	}
 
-	for _, sheetid := range ids {
-		fmt.Printf("SheetID: %s\n", sheetid)
-		dss, err := ds.NewDensitySpreadsheet(sheetid, ss)
+	if cfg.Archive.Enabled && cfg.Archive.Revisions {
+		gd, err := google.NewDrive(ctx, &cfg.Google, rec, slog.Default())
 		if err != nil {
-			fmt.Printf("Error in sheet %s: %v\n", sheetid, err)
-			continue
+			fmt.Printf("Drive error: %v\n", err)
+			os.Exit(1)
 		}
+		in.SetRevisionSource(gd)
+	}
 
-		ms, err := dss.GetSurveys()
-		if err != nil {
-			fmt.Printf("Measurement error in sheet %s: %v\n", sheetid, err)
-			continue
-		}
-		for _, m := range ms {
-			if err = m.LookupNames(); err != nil {
-				fmt.Printf(" !! Lookupnames failed: %v\n", err)
-			}
+	e := &env{
+		k: k,
+		cfg: cfg,
+		cassette: rec,
+		ingestor: in,
+	}
+	if len(args) > 1 {
+		e.args = args[1:]
+	}
+	if err = cmdf(ctx, e); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		if pool != nil {
+			pool.Close()
 		}
-		//fmt.Printf("Measurements in %s: %d\n", sheetid, len(ms))
-		//fmt.Printf("M: %+v\n", ms)
-		//os.Exit(0)
-
-		for _, m := range ms {
-			if err = db.Pool.AddSurvey(&m); err != nil {
-				fmt.Printf("AddMeasurement (%s): %v\n%+v\n\n", sheetid, err, m)
-			}
+		os.Exit(1)
+	}
+}
+
+// spreadsheetArgs are the spreadsheet IDs of the positional arguments,
+// which can be links as well
+func spreadsheetArgs(e *env) ([]string, error) {
+	ret := []string{}
+	for _, arg := range e.args {
+		id, err := ds.SpreadsheetID(arg)
+		if err != nil {
+			return nil, err
 		}
+		ret = append(ret, id)
 	}
+	return ret, nil
 }
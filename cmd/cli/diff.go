@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"os"
+	"fmt"
+	"errors"
+	"context"
+	"encoding/json"
+
+	ds "github.com/gczuczy/dw-stellar-density-analyzer/pkg/densitysurvey"
+)
+
+// runDiff compares the spreadsheets given as arguments with their stored
+// surveys
+func runDiff(ctx context.Context, e *env) error {
+	if len(e.args) == 0 {
+		return errors.New("diff: no spreadsheets given")
+	}
+
+	format := e.k.String(`format`)
+	if format != "text" && format != "json" {
+		return fmt.Errorf("diff: unknown format %s", format)
+	}
+
+	ids, err := spreadsheetArgs(e)
+	if err != nil {
+		return err
+	}
+	// parsed as they would be ingested
+	sources, err := entrySources(ctx, e)
+	if err != nil {
+		return err
+	}
+	diffs := []*ds.SpreadsheetDiff{}
+	for _, id := range ids {
+		in, entry := locate(ctx, e, sources, id)
+		d, err := in.Diff(ctx, entry)
+		if err != nil {
+			return errors.Join(err, fmt.Errorf("diff(%s)", id))
+		}
+		diffs = append(diffs, d)
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(diffs)
+	}
+	for _, d := range diffs {
+		d.Write(os.Stdout)
+	}
+	return nil
+}
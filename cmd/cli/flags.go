@@ -2,17 +2,29 @@ package cli
 
 import (
 	"os"
-	//	"fmt"
+	"fmt"
 
 	"github.com/knadh/koanf/v2"
 	"github.com/knadh/koanf/providers/posflag"
 	flag "github.com/spf13/pflag"
 )
 
-func parseArgs(k *koanf.Koanf) error {
+// parseArgs loads the flags into k, and returns the positional arguments
+func parseArgs(k *koanf.Koanf) ([]string, error) {
 
 	f := flag.NewFlagSet("config", flag.ContinueOnError)
 	f.Usage = func() {
+		fmt.Printf("Usage: %s [flags] [command] [args]\n\n", os.Args[0])
+		fmt.Printf("Commands:\n")
+		fmt.Printf("  ingest    ingest the sheets of the entry sheet and the discovery folders (default)\n")
+		fmt.Printf("  backfill  retry resolving the stored points without coordinates\n")
+		fmt.Printf("  reparse   parse the archived sheets again, without reading them from Google\n")
+		fmt.Printf("  diff      compare the spreadsheets given as arguments with their stored surveys\n")
+		fmt.Printf("  lint      explain how the spreadsheets given as arguments would be parsed, without the database\n")
+		fmt.Printf("  retracted list the retracted surveys, of the spreadsheets given as arguments or all\n")
+		fmt.Printf("  restore   restore the retracted surveys, of the spreadsheets given as arguments or all\n")
+		fmt.Printf("  purge     delete the retracted surveys, of the spreadsheets given as arguments or all\n")
+		fmt.Printf("\nFlags:\n")
 		f.PrintDefaults()
 		os.Exit(0)
 	}
@@ -20,9 +32,16 @@ func parseArgs(k *koanf.Koanf) error {
 	f.StringP("sa-creds", "s", "credentials.json", "The Google Service Account credentials json")
 	f.StringP("sheetid", "i", "", "The ID of the entrypoint google sheet (one sheet per A column, either link or ID)")
 	f.StringP("config", "c", "~/.edsda.yaml", "Path to the configuration file")
+	f.Bool("annotate", false, "ingest: annotate the problems on the cells of the survey sheets")
+	f.Duration("interval", 0, "backfill: repeat with this interval, 0 runs once")
+	f.Int("batch", 100, "backfill: max number of surveys per pass")
+	f.Bool("replace", false, "reparse: replace the stored surveys with the reparsed ones")
+	f.String("format", "text", "diff, lint: output format, text or json")
+	f.String("record", "", "Record the Google and EDSM responses into this directory")
+	f.String("replay", "", "Serve the Google and EDSM responses from this recorded directory")
 	if err := f.Parse(os.Args[1:]); err != nil {
-		return err
+		return nil, err
 	}
 
-	return k.Load(posflag.Provider(f, ".", k), nil)
+	return f.Args(), k.Load(posflag.Provider(f, ".", k), nil)
 }
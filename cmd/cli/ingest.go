@@ -0,0 +1,172 @@
+package cli
+
+import (
+	"os"
+	"fmt"
+	"errors"
+	"strings"
+	"context"
+	"log/slog"
+
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/google"
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/ingest"
+	ds "github.com/gczuczy/dw-stellar-density-analyzer/pkg/densitysurvey"
+)
+
+// source is an ingestor with the spreadsheet sources it ingests
+type source struct {
+	ingestor *ingest.Ingestor
+	entries []ingest.EntrySource
+	// the retraction key of the default source, the configured ones are
+	// keyed by the ingestor
+	key string
+}
+
+// runIngest ingests the spreadsheets of the entry sheet, the discovered
+// Drive folders, the configured sources and the form responses, then
+// retracts the surveys whose source disappeared
+func runIngest(ctx context.Context, e *env) error {
+	sources, err := entrySources(ctx, e)
+	if err != nil {
+		return err
+	}
+
+	if len(sources) == 0 && len(e.cfg.Forms.Sheets) == 0 {
+		return fmt.Errorf("Nothing to ingest, give an entry sheet (--sheetid), discovery.folders, sources or forms.sheets")
+	}
+
+	var reterr error = nil
+	reports := []*ingest.Report{}
+	for _, src := range sources {
+		report, err := src.ingestor.Ingest(ctx, src.entries...)
+		if src.key != "" {
+			report.Key = src.key
+		}
+		if err != nil {
+			report.Incomplete = true
+			report.Errors = append(report.Errors, err)
+			reterr = errors.Join(reterr, err)
+		}
+		reports = append(reports, report)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if len(e.cfg.Forms.Sheets) > 0 && ctx.Err() == nil {
+		report := &ingest.Report{
+			Source: ingest.FormsKey,
+		}
+		e.ingestor.IngestForms(ctx, report)
+		reports = append(reports, report)
+	}
+
+	for _, report := range reports {
+		report.Write(os.Stdout)
+	}
+
+	if ctx.Err() == nil {
+		n, err := e.ingestor.Retract(ctx, reports...)
+		if err != nil {
+			reterr = errors.Join(reterr, err)
+		} else if n > 0 {
+			fmt.Printf("Retracted surveys: %d\n", n)
+		}
+	}
+	return reterr
+}
+
+// entrySources sets up the default source of --sheetid and
+// discovery.folders, and the configured sources, in the order of ingesting
+func entrySources(ctx context.Context, e *env) ([]source, error) {
+	var gd *google.GDriveService
+
+	// the Drive client is only created when there are folders to discover
+	discovery := func(folders []string) (*ds.FolderDiscovery, error) {
+		if gd == nil {
+			var err error
+			if gd, err = google.NewDrive(ctx, &e.cfg.Google, e.cassette, slog.Default()); err != nil {
+				return nil, fmt.Errorf("Drive error: %w", err)
+			}
+		}
+		dcfg := e.cfg.Discovery
+		dcfg.Folders = folders
+		return ds.NewFolderDiscovery(gd, &dcfg)
+	}
+
+	sources := []source{}
+
+	// the default source of --sheetid and discovery.folders
+	def := source{
+		ingestor: e.ingestor,
+	}
+	keys := []string{}
+	if entryid := e.k.String(`sheetid`); entryid != "" {
+		entry, err := e.ingestor.EntrySheet(ctx, entryid)
+		if err != nil {
+			return nil, err
+		}
+		def.entries = append(def.entries, entry)
+		keys = append(keys, ingest.EntrySheetKey(entryid))
+	}
+	if len(e.cfg.Discovery.Folders) > 0 {
+		fd, err := discovery(e.cfg.Discovery.Folders)
+		if err != nil {
+			return nil, err
+		}
+		def.entries = append(def.entries, fd)
+		keys = append(keys, ingest.DiscoveryKey)
+	}
+	if len(def.entries) > 0 {
+		def.key = strings.Join(keys, "+")
+		sources = append(sources, def)
+	}
+
+	for i := range e.cfg.Sources {
+		scfg := &e.cfg.Sources[i]
+		in, err := e.ingestor.ForSource(scfg)
+		if err != nil {
+			return nil, err
+		}
+		src := source{
+			ingestor: in,
+		}
+		if scfg.EntrySheet != "" {
+			id, err := ds.SpreadsheetID(scfg.EntrySheet)
+			if err != nil {
+				return nil, errors.Join(err, fmt.Errorf("source %s: invalid entry sheet", scfg.Name))
+			}
+			entry, err := in.EntrySheet(ctx, id)
+			if err != nil {
+				return nil, errors.Join(err, fmt.Errorf("source %s: unable to open the entry sheet", scfg.Name))
+			}
+			src.entries = append(src.entries, entry)
+		}
+		if len(scfg.Folders) > 0 {
+			fd, err := discovery(scfg.Folders)
+			if err != nil {
+				return nil, errors.Join(err, fmt.Errorf("source %s", scfg.Name))
+			}
+			src.entries = append(src.entries, fd)
+		}
+		sources = append(sources, src)
+	}
+
+	return sources, nil
+}
+
+// locate finds the ingestor and the entry of the spreadsheet the way
+// runIngest would ingest it: the first source listing it, with the entry's
+// overrides and the source's parser. Without one it's the default ingestor
+// without overrides.
+func locate(ctx context.Context, e *env, sources []source, sheetid string) (*ingest.Ingestor, ds.Entry) {
+	for _, src := range sources {
+		if entry, ok := src.ingestor.Locate(ctx, sheetid, src.entries...); ok {
+			return src.ingestor, entry
+		}
+	}
+	return e.ingestor, ds.Entry{
+		SheetID: sheetid,
+		Row: -1,
+	}
+}
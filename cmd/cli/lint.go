@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"os"
+	"fmt"
+	"errors"
+	"context"
+	"encoding/json"
+
+	ds "github.com/gczuczy/dw-stellar-density-analyzer/pkg/densitysurvey"
+)
+
+// runLint explains how the spreadsheets given as arguments would be
+// ingested, without the database
+func runLint(ctx context.Context, e *env) error {
+	if len(e.args) == 0 {
+		return errors.New("lint: no spreadsheets given")
+	}
+
+	format := e.k.String(`format`)
+	if format != "text" && format != "json" {
+		return fmt.Errorf("lint: unknown format %s", format)
+	}
+
+	ids, err := spreadsheetArgs(e)
+	if err != nil {
+		return err
+	}
+	// parsed as they would be ingested
+	sources, err := entrySources(ctx, e)
+	if err != nil {
+		return err
+	}
+	reports := []*ds.LintReport{}
+	for _, id := range ids {
+		in, entry := locate(ctx, e, sources, id)
+		r, err := in.Lint(ctx, entry)
+		if err != nil {
+			return errors.Join(err, fmt.Errorf("lint(%s)", id))
+		}
+		reports = append(reports, r)
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(reports)
+	}
+	for _, r := range reports {
+		r.Write(os.Stdout)
+	}
+	return nil
+}
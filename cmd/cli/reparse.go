@@ -0,0 +1,14 @@
+package cli
+
+import (
+	"os"
+	"context"
+)
+
+// runReparse parses the archived sheets again, reporting the outcome or
+// replacing the stored surveys with --replace
+func runReparse(ctx context.Context, e *env) error {
+	report, err := e.ingestor.Reparse(ctx, e.k.Bool(`replace`))
+	report.Write(os.Stdout)
+	return err
+}
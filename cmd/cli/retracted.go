@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"fmt"
+	"context"
+)
+
+// runRetracted lists the retracted surveys of the spreadsheets given as
+// arguments, all of them without any
+func runRetracted(ctx context.Context, e *env) error {
+	ids, err := spreadsheetArgs(e)
+	if err != nil {
+		return err
+	}
+	surveys, err := e.ingestor.RetractedSurveys(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for _, rs := range surveys {
+		fmt.Printf("%d: %s/%s %s - %s retracted:%s\n", rs.ID, rs.SpreadsheetID, rs.Tab,
+			rs.CMDR, rs.Project, rs.Retracted.Format("2006-01-02 15:04:05"))
+	}
+	fmt.Printf("Retracted surveys: %d\n", len(surveys))
+	return nil
+}
+
+// runRestore clears the retraction of the surveys of the spreadsheets given
+// as arguments, all of them without any
+func runRestore(ctx context.Context, e *env) error {
+	ids, err := spreadsheetArgs(e)
+	if err != nil {
+		return err
+	}
+	n, err := e.ingestor.RestoreSurveys(ctx, ids)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored surveys: %d\n", n)
+	return nil
+}
+
+// runPurge deletes the retracted surveys of the spreadsheets given as
+// arguments, all of them without any
+func runPurge(ctx context.Context, e *env) error {
+	ids, err := spreadsheetArgs(e)
+	if err != nil {
+		return err
+	}
+	n, err := e.ingestor.PurgeSurveys(ctx, ids)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Purged surveys: %d\n", n)
+	return nil
+}
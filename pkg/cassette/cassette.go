@@ -0,0 +1,222 @@
+package cassette
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"sync"
+	"bytes"
+	"errors"
+	"net/url"
+	"net/http"
+	"path/filepath"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/config"
+)
+
+const (
+	ModeOff = "off"
+	ModeRecord = "record"
+	ModeReplay = "replay"
+)
+
+// Cassette records the HTTP responses of a run into a directory, or serves
+// them from there without any network access. Identical requests are told
+// apart by their order, so a replayed run sees the responses in the same
+// sequence as the recorded one.
+type Cassette struct {
+	mode string
+	dir string
+	mtx sync.Mutex
+	// the number of times a request was seen, per key
+	seen map[string]int
+}
+
+// episode is a recorded request and its response
+type episode struct {
+	Method string `json:"method"`
+	URL string `json:"url"`
+	RequestBody string `json:"requestbody,omitempty"`
+	Status int `json:"status"`
+	Header http.Header `json:"header"`
+	Body string `json:"body"`
+}
+
+// New creates the cassette, nil when it's turned off
+func New(cfg *config.CassetteConfig) (*Cassette, error) {
+	switch cfg.Mode {
+	case ModeOff, "":
+		return nil, nil
+	case ModeRecord:
+		if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+			return nil, errors.Join(err, fmt.Errorf("Unable to create cassette dir %s", cfg.Dir))
+		}
+	case ModeReplay:
+		if _, err := os.Stat(cfg.Dir); err != nil {
+			return nil, errors.Join(err, fmt.Errorf("Unable to replay cassette %s", cfg.Dir))
+		}
+	default:
+		return nil, fmt.Errorf("Unknown cassette mode %q", cfg.Mode)
+	}
+
+	return &Cassette{
+		mode: cfg.Mode,
+		dir: cfg.Dir,
+		seen: map[string]int{},
+	}, nil
+}
+
+// Replaying tells whether the responses are served from the disk
+func (c *Cassette) Replaying() bool {
+	return c != nil && c.mode == ModeReplay
+}
+
+// Client wraps the client's transport, the recordings are kept under the
+// name. With a nil cassette it returns the client as is.
+func (c *Cassette) Client(name string, client *http.Client) *http.Client {
+	if c == nil {
+		return client
+	}
+	if client == nil {
+		client = &http.Client{}
+	}
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	ret := *client
+	ret.Transport = &transport{
+		cassette: c,
+		name: name,
+		base: base,
+	}
+	return &ret
+}
+
+type transport struct {
+	cassette *Cassette
+	name string
+	base http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+	c := t.cassette
+	key := requestKey(req, body)
+
+	c.mtx.Lock()
+	n := c.seen[key]
+	c.seen[key] += 1
+	c.mtx.Unlock()
+
+	if c.mode == ModeReplay {
+		ep, err := c.load(t.name, key, n)
+		if err != nil {
+			return nil, errors.Join(err, fmt.Errorf("No recording of %s %s", req.Method, redactURL(req.URL)))
+		}
+		return &http.Response{
+			Status: fmt.Sprintf("%d %s", ep.Status, http.StatusText(ep.Status)),
+			StatusCode: ep.Status,
+			Proto: "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header: ep.Header,
+			Body: io.NopCloser(bytes.NewReader([]byte(ep.Body))),
+			ContentLength: int64(len(ep.Body)),
+			Request: req,
+		}, nil
+	}
+
+	out := req.Clone(req.Context())
+	if body != nil {
+		out.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	resp, err := t.base.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	respbody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respbody))
+
+	err = c.save(t.name, key, n, &episode{
+		Method: req.Method,
+		URL: redactURL(req.URL),
+		RequestBody: string(body),
+		Status: resp.StatusCode,
+		Header: resp.Header,
+		Body: string(respbody),
+	})
+	return resp, err
+}
+
+func (c *Cassette) path(name, key string, n int) string {
+	return filepath.Join(c.dir, name, fmt.Sprintf("%s-%d.json", key, n))
+}
+
+// load returns the nth recording of the request, or the last one if the
+// request was repeated more times than recorded
+func (c *Cassette) load(name, key string, n int) (*episode, error) {
+	var (
+		data []byte
+		err error
+	)
+	for i := n; i >= 0; i -= 1 {
+		if data, err = os.ReadFile(c.path(name, key, i)); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ep := &episode{}
+	if err = json.Unmarshal(data, ep); err != nil {
+		return nil, err
+	}
+	return ep, nil
+}
+
+func (c *Cassette) save(name, key string, n int, ep *episode) error {
+	data, err := json.MarshalIndent(ep, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := c.path(name, key, n)
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// requestKey identifies a request by its method, URL and body, the
+// credentials in the URL are left out
+func requestKey(req *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", req.Method, redactURL(req.URL))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))[:24]
+}
+
+// redactURL drops the API key, the query is sorted by Encode
+func redactURL(u *url.URL) string {
+	r := *u
+	q := r.Query()
+	q.Del("key")
+	r.RawQuery = q.Encode()
+	return r.String()
+}
@@ -0,0 +1,138 @@
+package cassette
+
+import (
+	"io"
+	"fmt"
+	"strings"
+	"testing"
+	"net/url"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/config"
+)
+
+func TestRedactURL(t *testing.T) {
+	tests := []struct {
+		in string
+		want string
+	}{
+		{"https://sheets.googleapis.com/v4/spreadsheets/abc?key=secret&alt=json",
+			"https://sheets.googleapis.com/v4/spreadsheets/abc?alt=json"},
+		{"https://www.edsm.net/api-v1/systems?systemName%5B%5D=Sol&showCoordinates=1",
+			"https://www.edsm.net/api-v1/systems?showCoordinates=1&systemName%5B%5D=Sol"},
+		{"https://example.com/path?key=secret", "https://example.com/path"},
+		{"https://example.com/path", "https://example.com/path"},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := redactURL(u); got != tt.want {
+			t.Errorf("redactURL(%s) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRequestKey(t *testing.T) {
+	key := func(method, rawurl, body string) string {
+		req, err := http.NewRequest(method, rawurl, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return requestKey(req, []byte(body))
+	}
+
+	base := key("GET", "https://example.com/v4?a=1&b=2&key=one", "")
+	tests := []struct {
+		name string
+		key string
+		same bool
+	}{
+		{"another API key", key("GET", "https://example.com/v4?a=1&b=2&key=two", ""), true},
+		{"query order", key("GET", "https://example.com/v4?b=2&a=1", ""), true},
+		{"method", key("POST", "https://example.com/v4?a=1&b=2", ""), false},
+		{"query", key("GET", "https://example.com/v4?a=1&b=3", ""), false},
+		{"body", key("GET", "https://example.com/v4?a=1&b=2", "{}"), false},
+	}
+	for _, tt := range tests {
+		if (tt.key == base) != tt.same {
+			t.Errorf("%s: key %s, base %s, same %v", tt.name, tt.key, base, tt.same)
+		}
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls += 1
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "%s %d", r.URL.Query().Get("q"), calls)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	get := func(client *http.Client, q string) (string, error) {
+		resp, err := client.Get(srv.URL + "/?key=secret&q=" + q)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	rec, err := New(&config.CassetteConfig{Mode: ModeRecord, Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := rec.Client("test", nil)
+	recorded := []string{}
+	for _, q := range []string{"a", "b", "a"} {
+		body, err := get(client, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorded = append(recorded, body)
+	}
+
+	srv.Close()
+	play, err := New(&config.CassetteConfig{Mode: ModeReplay, Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !play.Replaying() || rec.Replaying() {
+		t.Errorf("Replaying")
+	}
+	client = play.Client("test", nil)
+	// the repeated request gets its responses in order, then the last one
+	want := []string{recorded[0], recorded[1], recorded[2], recorded[2]}
+	for i, q := range []string{"a", "b", "a", "a"} {
+		body, err := get(client, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body != want[i] {
+			t.Errorf("replay %d: %q, want %q", i, body, want[i])
+		}
+	}
+	if _, err := get(client, "c"); err == nil || !strings.Contains(err.Error(), "No recording") {
+		t.Errorf("unrecorded request: %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if c, err := New(&config.CassetteConfig{Mode: ModeOff}); c != nil || err != nil {
+		t.Errorf("off: %v, %v", c, err)
+	}
+	if c := (*Cassette)(nil); c.Replaying() || c.Client("x", nil) != nil {
+		t.Errorf("nil cassette")
+	}
+	if _, err := New(&config.CassetteConfig{Mode: ModeReplay, Dir: t.TempDir() + "/missing"}); err == nil {
+		t.Errorf("replaying a missing dir")
+	}
+	if _, err := New(&config.CassetteConfig{Mode: "rewind"}); err == nil {
+		t.Errorf("unknown mode")
+	}
+}
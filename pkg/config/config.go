@@ -1,6 +1,10 @@
 package config
 
 import (
+	"fmt"
+	"time"
+	"errors"
+
 	"github.com/knadh/koanf/v2"
 	"github.com/knadh/koanf/parsers/yaml"
 	"github.com/knadh/koanf/providers/file"
@@ -8,6 +12,21 @@ import (
 
 type Config struct {
 	DB DBConfig `koanf:"db"`
+	Google GoogleConfig `koanf:"google"`
+	Validation ValidationConfig `koanf:"validation"`
+	Variants VariantsConfig `koanf:"variants"`
+	Metadata MetadataConfig `koanf:"metadata"`
+	Writeback WritebackConfig `koanf:"writeback"`
+	Cassette CassetteConfig `koanf:"cassette"`
+	Discovery DiscoveryConfig `koanf:"discovery"`
+	EntrySheet EntrySheetConfig `koanf:"entrysheet"`
+	Forms FormsConfig `koanf:"forms"`
+	Archive ArchiveConfig `koanf:"archive"`
+	// the expeditions ingested with their own settings, besides --sheetid
+	// and discovery.folders
+	Sources []SourceConfig `koanf:"sources"`
+	// annotate the problems on the cells of the survey sheets
+	Annotate bool `koanf:"annotate"`
 }
 
 type DBConfig struct {
@@ -20,6 +39,247 @@ type DBConfig struct {
 	MinConns int32 `koanf:"minconns"`
 }
 
+// Authentication to the Google APIs
+type GoogleConfig struct {
+	// serviceaccount, adc, oauth, apikey or impersonate
+	Auth string `koanf:"auth"`
+	// the service account's credentials json, defaults to --sa-creds
+	Credentials string `koanf:"credentials"`
+	// apikey: only publicly shared sheets can be read
+	APIKey string `koanf:"apikey"`
+	OAuth OAuthConfig `koanf:"oauth"`
+	Impersonate ImpersonateConfig `koanf:"impersonate"`
+	RateLimit RateLimitConfig `koanf:"ratelimit"`
+}
+
+// Pacing and retrying the Google API calls
+type RateLimitConfig struct {
+	// 0 disables the pacing
+	RequestsPerMinute int `koanf:"requestsperminute"`
+	MaxRetries int `koanf:"maxretries"`
+	// the first retry's delay, doubled on each further one
+	BaseDelay time.Duration `koanf:"basedelay"`
+	MaxDelay time.Duration `koanf:"maxdelay"`
+}
+
+// Interactive user authentication, the CMDRs can share with a user
+type OAuthConfig struct {
+	// the OAuth client json (desktop app) from the Cloud Console
+	ClientSecrets string `koanf:"clientsecrets"`
+	// the user's token is kept here between the runs
+	TokenCache string `koanf:"tokencache"`
+}
+
+// Acting as a service account with the ADC or the credentials file
+type ImpersonateConfig struct {
+	// the email of the impersonated service account
+	Target string `koanf:"target"`
+	// the chain of service accounts to the target, optional
+	Delegates []string `koanf:"delegates"`
+}
+
+// The layout of the entry sheet, columns are letters, the optional ones
+// can be left empty
+type EntrySheetConfig struct {
+	// the first row having entries, counted from 1, the ones above are headers
+	FirstRow int `koanf:"firstrow"`
+	Columns EntryColumns `koanf:"columns"`
+	// written after each ingest
+	Status EntryStatusColumns `koanf:"status"`
+}
+
+type EntryColumns struct {
+	// the link or the ID of the survey spreadsheet
+	ID string `koanf:"id"`
+	// overriding the project of the sheets' A1
+	Campaign string `koanf:"campaign"`
+	// overriding the CMDR of the sheets' A1
+	CMDR string `koanf:"cmdr"`
+	// a checkbox, or x/yes/true to leave the row out
+	Skip string `koanf:"skip"`
+	Notes string `koanf:"notes"`
+}
+
+type EntryStatusColumns struct {
+	LastIngested string `koanf:"lastingested"`
+	Result string `koanf:"result"`
+	Points string `koanf:"points"`
+	Errors string `koanf:"errors"`
+}
+
+// Keeping the raw values of the ingested sheets in the database, for the
+// reparse command
+type ArchiveConfig struct {
+	Enabled bool `koanf:"enabled"`
+	// record the spreadsheets' Drive version with them
+	Revisions bool `koanf:"revisions"`
+}
+
+// Google Forms response spreadsheets, each response is a survey. The
+// questions are the headers of the first row, matched case-insensitively.
+type FormsConfig struct {
+	// the IDs or links of the response spreadsheets
+	Sheets []string `koanf:"sheets"`
+	// the responses' sheet, the first one if empty
+	Tab string `koanf:"tab"`
+	Timestamp string `koanf:"timestamp"`
+	Email string `koanf:"email"`
+	CMDR string `koanf:"cmdr"`
+	Campaign string `koanf:"campaign"`
+	// the questions of the samples, %d is the number of the sample from 1
+	System string `koanf:"system"`
+	ZSample string `koanf:"zsample"`
+	SystemCount string `koanf:"systemcount"`
+	MaxDistance string `koanf:"maxdistance"`
+	// the z-samples when the form doesn't ask for them, by sample number
+	Heights []float32 `koanf:"heights"`
+	// used when the max distance is not asked or left empty
+	DefaultMaxDistance float32 `koanf:"defaultmaxdistance"`
+}
+
+// Finding the survey spreadsheets in Drive folders
+type DiscoveryConfig struct {
+	// folder IDs or links
+	Folders []string `koanf:"folders"`
+	// descend into the subfolders
+	Recursive bool `koanf:"recursive"`
+	// regular expression the spreadsheet names have to match, optional
+	Name string `koanf:"name"`
+	// only the spreadsheets owned by these (email or name), optional
+	Owners []string `koanf:"owners"`
+}
+
+// An expedition's survey spreadsheets, with an entry sheet, Drive folders
+// or both. The layout of the entry sheet is taken from entrysheet, the
+// folders are searched as configured in discovery.
+type SourceConfig struct {
+	// shown in the report
+	Name string `koanf:"name"`
+	// the ID or link of the entry sheet
+	EntrySheet string `koanf:"entrysheet"`
+	// folder IDs or links
+	Folders []string `koanf:"folders"`
+	// the campaign of the surveys whose sheet doesn't name one
+	Campaign string `koanf:"campaign"`
+	// the names of the sheet variants accepted, "detected" allows the
+	// header-driven detection. All of them if empty.
+	Variants []string `koanf:"variants"`
+	// the expected heights, instead of the variants' ones
+	Schedule ScheduleConfig `koanf:"schedule"`
+}
+
+// Recording the Google and EDSM responses, or replaying them offline
+type CassetteConfig struct {
+	// off, record or replay
+	Mode string `koanf:"mode"`
+	Dir string `koanf:"dir"`
+}
+
+// Limits of the geometric validation, in lightyears. 0 disables the check
+type ValidationConfig struct {
+	MaxHeightDeviation float32 `koanf:"maxheightdeviation"`
+	MaxColumnDrift float32 `koanf:"maxcolumndrift"`
+	// between the sheet's and the resolved coordinates
+	MaxCoordinateMismatch float32 `koanf:"maxcoordinatemismatch"`
+}
+
+// Writing the computed results back to the survey spreadsheets
+type WritebackConfig struct {
+	// the name of the results sheet created in the survey spreadsheets
+	Tab string `koanf:"tab"`
+	// only surveys of these campaigns are written back
+	Campaigns []string `koanf:"campaigns"`
+}
+
+// The survey sheet layouts on top of the compiled in ones
+type VariantsConfig struct {
+	// whether the built-in variants are used after the configured ones
+	Builtins bool `koanf:"builtins"`
+	// a separate YAML file with more definitions
+	File string `koanf:"file"`
+	Definitions []SheetVariant `koanf:"definitions"`
+	Detect DetectConfig `koanf:"detect"`
+}
+
+// Extracting the survey's CMDR, project, date and notes. The patterns are
+// regular expressions with the optional named groups cmdr, project, date
+// and notes; A1 is tried first, then the tab's and the spreadsheet's title
+// for what's still missing. Empty patterns are not used.
+type MetadataConfig struct {
+	A1 string `koanf:"a1"`
+	Tab string `koanf:"tab"`
+	Title string `koanf:"title"`
+	// cells with the survey's date and notes, A1 references, optional
+	DateCell string `koanf:"datecell"`
+	NotesCell string `koanf:"notescell"`
+	// Go time layouts of the dates given as text, in-game years (3300+)
+	// are converted
+	DateFormats []string `koanf:"dateformats"`
+}
+
+// Header-driven detection, used when none of the variants match
+type DetectConfig struct {
+	Enabled bool `koanf:"enabled"`
+	// the number of top rows scanned for a header row
+	ScanRows int `koanf:"scanrows"`
+	MinSampleRatio float32 `koanf:"minsampleratio"`
+	// extra header names per column, keyed by the VariantColumns names
+	Synonyms map[string][]string `koanf:"synonyms"`
+}
+
+// A survey sheet layout. Rows are numbered as in the sheet, from 1,
+// columns and cells are given as letters and A1 references.
+type SheetVariant struct {
+	Name string `koanf:"name"`
+	HeaderRow int `koanf:"headerrow"`
+	HeaderChecks []HeaderCheck `koanf:"headerchecks"`
+	Columns VariantColumns `koanf:"columns"`
+	// 0..1, minimum ratio of samples filled in the survey sheet
+	MinSampleRatio float32 `koanf:"minsampleratio"`
+	// used when the max distance cell is empty
+	DefaultMaxDistance float32 `koanf:"defaultmaxdistance"`
+	// the number of rows used from the sheet, 0 for all of them
+	MaxRows int `koanf:"maxrows"`
+	// only sheets of this project (A1's "CMDR - Project") match
+	Project string `koanf:"project"`
+	// the expected heights, optional
+	Schedule ScheduleConfig `koanf:"schedule"`
+}
+
+// The heights a survey is expected to sample.
+// linear: start + i*step, logarithmic: start * factor^i, for i in 0..count-1
+type ScheduleConfig struct {
+	// linear or logarithmic, empty for no schedule
+	Type string `koanf:"type"`
+	Start float32 `koanf:"start"`
+	Step float32 `koanf:"step"`
+	Factor float32 `koanf:"factor"`
+	Count int `koanf:"count"`
+	// mirrored below the galactic plane
+	Signed bool `koanf:"signed"`
+	// 0 is part of the schedule
+	Zero bool `koanf:"zero"`
+	// in ly for linear, relative to the height for logarithmic
+	Tolerance float32 `koanf:"tolerance"`
+}
+
+type HeaderCheck struct {
+	Cell string `koanf:"cell"`
+	Value string `koanf:"value"`
+}
+
+// Column letters, the optional ones can be left empty
+type VariantColumns struct {
+	SampleIndicator string `koanf:"sampleindicator"`
+	SysName string `koanf:"sysname"`
+	ZSample string `koanf:"zsample"`
+	SystemCount string `koanf:"systemcount"`
+	MaxDistance string `koanf:"maxdistance"`
+	X string `koanf:"x"`
+	Z string `koanf:"z"`
+	Y string `koanf:"y"`
+}
+
 func ParseConfig(k *koanf.Koanf) (*Config, error) {
 	var (
 		err error
@@ -35,10 +295,105 @@ func ParseConfig(k *koanf.Koanf) (*Config, error) {
 			MaxConns: 8,
 			MinConns: 1,
 		},
+		Google: GoogleConfig{
+			Auth: "serviceaccount",
+			OAuth: OAuthConfig{
+				TokenCache: "edsda-token.json",
+			},
+			// the Sheets read quota is 60/min/user
+			RateLimit: RateLimitConfig{
+				RequestsPerMinute: 55,
+				MaxRetries: 6,
+				BaseDelay: 2 * time.Second,
+				MaxDelay: 64 * time.Second,
+			},
+		},
+		Validation: ValidationConfig{
+			MaxHeightDeviation: 25,
+			MaxColumnDrift: 100,
+			MaxCoordinateMismatch: 5,
+		},
+		Writeback: WritebackConfig{
+			Tab: "EDSDA Results",
+		},
+		EntrySheet: EntrySheetConfig{
+			FirstRow: 1,
+			Columns: EntryColumns{
+				ID: "A",
+			},
+		},
+		Discovery: DiscoveryConfig{
+			Recursive: true,
+		},
+		Forms: FormsConfig{
+			Timestamp: "Timestamp",
+			Email: "Email Address",
+			CMDR: "CMDR",
+			Campaign: "Campaign",
+			System: "System %d",
+			ZSample: "Z Sample %d",
+			SystemCount: "System Count %d",
+			MaxDistance: "Max Distance %d",
+			DefaultMaxDistance: 20,
+		},
+		Cassette: CassetteConfig{
+			Mode: "off",
+			Dir: "cassettes",
+		},
+		Metadata: MetadataConfig{
+			A1: `^\s*(?P<cmdr>.+?)\s+-\s+(?P<project>.+?)\s*$`,
+			Title: `^\s*(?:CMDR\s+)?(?P<cmdr>.+?)\s+-\s+(?P<project>.+?)\s*$`,
+			DateFormats: []string{"2006-01-02", "2006.01.02", "2006/01/02", "2 Jan 2006", "January 2, 2006"},
+		},
+		Variants: VariantsConfig{
+			Builtins: true,
+			Detect: DetectConfig{
+				Enabled: true,
+				ScanRows: 10,
+				MinSampleRatio: 0.5,
+			},
+		},
 	}
 	if err = k.Unmarshal("", &cfg); err != nil {
 		return nil, err
 	}
 
+	if cfg.Google.Credentials == "" {
+		cfg.Google.Credentials = k.String(`sa-creds`)
+	}
+
+	if dir := k.String(`record`); dir != "" {
+		cfg.Cassette = CassetteConfig{Mode: "record", Dir: dir}
+	}
+	if dir := k.String(`replay`); dir != "" {
+		cfg.Cassette = CassetteConfig{Mode: "replay", Dir: dir}
+	}
+
+	if cfg.Variants.File != "" {
+		vk := koanf.New(".")
+		if err = vk.Load(file.Provider(cfg.Variants.File), yaml.Parser()); err != nil {
+			return nil, errors.Join(err, fmt.Errorf("Unable to load variants file %s", cfg.Variants.File))
+		}
+		defs := []SheetVariant{}
+		if err = vk.Unmarshal("definitions", &defs); err != nil {
+			return nil, errors.Join(err, fmt.Errorf("Unable to parse variants file %s", cfg.Variants.File))
+		}
+		cfg.Variants.Definitions = append(cfg.Variants.Definitions, defs...)
+	}
+
+	names := map[string]bool{}
+	for i, src := range cfg.Sources {
+		if src.Name == "" {
+			return nil, fmt.Errorf("source #%d has no name", i+1)
+		}
+		if names[src.Name] {
+			return nil, fmt.Errorf("source #%d: duplicate name %s", i+1, src.Name)
+		}
+		names[src.Name] = true
+		if src.EntrySheet == "" && len(src.Folders) == 0 {
+			return nil, fmt.Errorf("source %s has neither an entry sheet nor folders", src.Name)
+		}
+	}
+
 	return &cfg, nil
 }
@@ -0,0 +1,147 @@
+package db
+
+import (
+	"io"
+	"fmt"
+	"bytes"
+	"errors"
+	"context"
+	"crypto/sha256"
+	"compress/gzip"
+	"encoding/json"
+
+	"github.com/jackc/pgx/v5"
+	"google.golang.org/api/sheets/v4"
+
+	ds "github.com/gczuczy/dw-stellar-density-analyzer/pkg/densitysurvey"
+)
+
+// ArchiveTab stores the raw values of the tab compressed, and sets its ID.
+// When the latest archived version of the tab has the same revision or
+// values, and the same overrides, it's not stored again, its ID is used.
+func (p *DBPool) ArchiveTab(ctx context.Context, t *ds.RawTab) error {
+	var values [][]interface{}
+	if t.Values != nil {
+		values = t.Values.Values
+	}
+
+	raw, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(raw)
+
+	var (
+		latest int
+		same bool
+	)
+	err = p.pool.QueryRow(ctx, "latestrawtab", t.SpreadsheetID, t.Tab, nullString(t.Revision), sum[:],
+		nullString(t.CMDR), nullString(t.Project), nullString(t.Title), nullString(t.Source)).Scan(&latest, &same)
+	switch {
+	case err == nil && same:
+		t.ID = latest
+		return nil
+	case err != nil && !errors.Is(err, pgx.ErrNoRows):
+		return errors.Join(err, fmt.Errorf("Unable to check the archive of %s/%s", t.SpreadsheetID, t.Tab))
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err = zw.Write(raw); err != nil {
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		return err
+	}
+
+	err = p.pool.QueryRow(ctx, "archivetab", t.SpreadsheetID, t.Tab, t.Fetched,
+		nullString(t.Revision), nullString(t.CMDR), nullString(t.Project), buf.Bytes(),
+		nullString(t.Title), nullString(t.Source), sum[:]).Scan(&t.ID)
+	if err != nil {
+		return errors.Join(err, fmt.Errorf("Unable to archive %s/%s", t.SpreadsheetID, t.Tab))
+	}
+	return nil
+}
+
+// ArchivedTabs loads the latest archived version of every tab, except the
+// retracted ones
+func (p *DBPool) ArchivedTabs(ctx context.Context) ([]ds.RawTab, error) {
+	ret := []ds.RawTab{}
+
+	rows, err := p.pool.Query(ctx, "archivedtabs")
+	if err != nil {
+		return ret, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			t ds.RawTab
+			data []byte
+		)
+		if err = rows.Scan(&t.ID, &t.SpreadsheetID, &t.Tab, &t.Fetched, &t.Revision,
+			&t.CMDR, &t.Project, &data, &t.Title, &t.Source); err != nil {
+			return ret, err
+		}
+		if t.Values, err = decodeValues(data); err != nil {
+			return ret, errors.Join(err, fmt.Errorf("Corrupt archive of %s/%s", t.SpreadsheetID, t.Tab))
+		}
+		ret = append(ret, t)
+	}
+
+	return ret, rows.Err()
+}
+
+// ReplaceSurveys deletes the surveys of the tab stored earlier by the
+// source, archived or not, and stores the given ones instead. The retracted
+// surveys are kept as they are.
+func (p *DBPool) ReplaceSurveys(ctx context.Context, spreadsheetid, tab, source string, ms []ds.Survey) (err error) {
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback(ctx)
+			return
+		}
+		err = tx.Commit(ctx)
+	}()
+
+	if _, err = tx.Exec(ctx, "deletetabpoints", spreadsheetid, tab, nullString(source)); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(ctx, "deletetabsurveys", spreadsheetid, tab, nullString(source)); err != nil {
+		return err
+	}
+	for i := range ms {
+		if err = addSurvey(ctx, tx, &ms[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func decodeValues(data []byte) (*sheets.ValueRange, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+
+	vr := &sheets.ValueRange{}
+	if err = json.Unmarshal(raw, &vr.Values); err != nil {
+		return nil, err
+	}
+	return vr, nil
+}
+
+func nullString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
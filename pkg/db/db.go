@@ -15,32 +15,158 @@ import (
 )
 
 var (
-	Pool *DBPool=nil
-
 	prepared = map[string]string{
 		"addsheetsurvey": `
-SELECT density.addsheetsurvey($1::text, $2::text)
+SELECT density.addsheetsurvey($1::text, $2::text, $3::text[], $4::timestamptz, $5::text, $6::int,
+       $7::text, $8::text, $9::date, $10::text, $11::text)
 `,
-		// surveyid, sysname, x,y,z, syscount, maxdistance
+		// surveyid, sysname, x,y,z, syscount, maxdistance, flags, coordsource
 		"addsurveypoint": `
-INSERT INTO density.surveypoints (surveyid, sysname, zsample, x,y,z, syscount, maxdistance)
-VALUES ($1::int, $2::text, $3::int, $4::real, $5::real, $6::real, $7::int, $8::real)
+INSERT INTO density.surveypoints (surveyid, sysname, zsample, x,y,z, syscount, maxdistance, flags, coordsource)
+VALUES ($1::int, $2::text, $3::real, $4::real, $5::real, $6::real, $7::int, $8::real, $9::text[], $10::text)
+`,
+		// lowercased sysnames
+		"resolvecached": `
+SELECT DISTINCT ON (lower(sp.sysname)) lower(sp.sysname), sp.x, sp.y, sp.z
+FROM density.surveypoints sp
+WHERE lower(sp.sysname) = ANY($1::text[]) AND sp.x IS NOT NULL AND sp.coordsource <> 'sheet'
+`,
+		// limit, the least recently tried ones first
+		"unresolvedsurveys": `
+SELECT sp.surveyid
+FROM density.surveypoints sp
+     JOIN density.surveys s ON s.id = sp.surveyid
+WHERE (sp.x IS NULL OR sp.coordsource = 'sheet') AND s.retracted IS NULL
+GROUP BY sp.surveyid
+ORDER BY max(sp.lastresolve) NULLS FIRST, sp.surveyid
+LIMIT $1::int
+`,
+		// surveyid
+		"surveypoints": `
+SELECT sp.sysname, sp.zsample, sp.x, sp.y, sp.z, sp.coordsource, sp.syscount, sp.maxdistance, sp.flags
+FROM density.surveypoints sp
+WHERE sp.surveyid = $1::int
+ORDER BY sp.zsample
+`,
+		// surveyid, sysname, x,y,z, coordsource, flags
+		"updatesurveypoint": `
+UPDATE density.surveypoints
+SET x = $3::real, y = $4::real, z = $5::real, coordsource = $6::text, flags = $7::text[]
+WHERE surveyid = $1::int AND sysname = $2::text
+`,
+		// spreadsheetid, the latest survey of every tab
+		"latestsurveys": `
+SELECT DISTINCT ON (s.tab) s.tab, s.id
+FROM density.surveys s
+WHERE s.spreadsheetid = $1::text AND s.retracted IS NULL
+ORDER BY s.tab, s.id DESC
+`,
+		// spreadsheetid, tab, revision, cmdr, campaign, data, title, source, hash
+		"archivetab": `
+INSERT INTO density.rawtabs (spreadsheetid, tab, fetched, revision, cmdr, campaign, data, title, source, hash)
+VALUES ($1::text, $2::text, $3::timestamptz, $4::text, $5::text, $6::text, $7::bytea, $8::text, $9::text,
+       $10::bytea)
+RETURNING id
+`,
+		// spreadsheetid, tab, revision, hash, cmdr, campaign, title, source;
+		// the latest archived version, and whether it's the same
+		"latestrawtab": `
+SELECT rt.id,
+       coalesce(rt.revision = $3::text OR rt.hash = $4::bytea, false)
+       AND rt.cmdr IS NOT DISTINCT FROM $5::text AND rt.campaign IS NOT DISTINCT FROM $6::text
+       AND rt.title IS NOT DISTINCT FROM $7::text AND rt.source IS NOT DISTINCT FROM $8::text
+FROM density.rawtabs rt
+WHERE rt.spreadsheetid = $1::text AND rt.tab = $2::text
+ORDER BY rt.fetched DESC
+LIMIT 1
+`,
+		// the latest fetch of every tab by every source
+		"archivedtabs": `
+SELECT DISTINCT ON (rt.spreadsheetid, rt.tab, rt.source) rt.id, rt.spreadsheetid, rt.tab, rt.fetched,
+       coalesce(rt.revision, ''), coalesce(rt.cmdr, ''), coalesce(rt.campaign, ''), rt.data,
+       coalesce(rt.title, ''), coalesce(rt.source, '')
+FROM density.rawtabs rt
+-- the tabs retracted, without surveys stored since
+WHERE NOT (EXISTS (SELECT 1 FROM density.surveys s
+      	   	   WHERE s.spreadsheetid = rt.spreadsheetid AND s.tab = rt.tab AND s.retracted IS NOT NULL)
+	   AND NOT EXISTS (SELECT 1 FROM density.surveys s
+	       	       	   WHERE s.spreadsheetid = rt.spreadsheetid AND s.tab = rt.tab AND s.retracted IS NULL))
+ORDER BY rt.spreadsheetid, rt.tab, rt.source, rt.fetched DESC
+`,
+		// spreadsheetid, tab, source; archived or not, the retracted ones are kept
+		"deletetabpoints": `
+DELETE FROM density.surveypoints sp
+USING density.surveys s
+WHERE sp.surveyid = s.id AND s.spreadsheetid = $1::text AND s.tab = $2::text
+AND s.source IS NOT DISTINCT FROM $3::text AND s.retracted IS NULL
+`,
+		// spreadsheetid, tab, source; archived or not, the retracted ones are kept
+		"deletetabsurveys": `
+DELETE FROM density.surveys s
+WHERE s.spreadsheetid = $1::text AND s.tab = $2::text
+AND s.source IS NOT DISTINCT FROM $3::text AND s.retracted IS NULL
+`,
+		"addrun": `
+INSERT INTO density.runs DEFAULT VALUES RETURNING id
+`,
+		// runid, source
+		"addrunsource": `
+INSERT INTO density.runsources (runid, source) VALUES ($1::int, $2::text)
+`,
+		// runid, source, spreadsheetid, tab
+		"addrunsheet": `
+INSERT INTO density.runsheets (runid, source, spreadsheetid, tab)
+VALUES ($1::int, $2::text, $3::text, $4::text)
+`,
+		// runid
+		"retract": `
+SELECT density.retract($1::int)
+`,
+		// spreadsheetids, all of them if it's empty
+		"retractedsurveys": `
+SELECT r.id, r.cmdrname, r.campaignname, r.spreadsheetid, r.tab, r.retracted
+FROM density.v_retracted r
+WHERE cardinality($1::text[]) = 0 OR r.spreadsheetid = ANY($1::text[])
+ORDER BY r.spreadsheetid, r.tab, r.id
+`,
+		// spreadsheetids, all of them if it's empty
+		"restoresurveys": `
+UPDATE density.surveys
+SET retracted = NULL
+WHERE retracted IS NOT NULL AND (cardinality($1::text[]) = 0 OR spreadsheetid = ANY($1::text[]))
+`,
+		// spreadsheetids, all of them if it's empty
+		"purgepoints": `
+DELETE FROM density.surveypoints sp
+USING density.surveys s
+WHERE sp.surveyid = s.id AND s.retracted IS NOT NULL
+AND (cardinality($1::text[]) = 0 OR s.spreadsheetid = ANY($1::text[]))
+`,
+		// spreadsheetids, all of them if it's empty
+		"purgesurveys": `
+DELETE FROM density.surveys
+WHERE retracted IS NOT NULL AND (cardinality($1::text[]) = 0 OR spreadsheetid = ANY($1::text[]))
+`,
+		// surveyid
+		"markresolveattempt": `
+UPDATE density.surveypoints
+SET resolveattempts = resolveattempts + 1, lastresolve = now()
+WHERE surveyid = $1::int AND (x IS NULL OR coordsource = 'sheet')
 `,
 	}
 )
 
 type DBPool struct {
-	ctx context.Context
 	pool *pgxpool.Pool
 }
 
-// init the DBPool and store it in the global variable
-func Init(cfg *config.DBConfig) error {
+// New connects to the database, the pool is ready to use once it returns
+func New(ctx context.Context, cfg *config.DBConfig) (*DBPool, error) {
 	var err error
 
 	dbcfg, err := pgxpool.ParseConfig("")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	dbcfg.MaxConnLifetime = 8 * time.Hour
 	dbcfg.MaxConns = cfg.MaxConns
@@ -55,22 +181,25 @@ func Init(cfg *config.DBConfig) error {
 		dbcfg.ConnConfig.Port = (*cfg.Port)
 	}
 
-	dbp := DBPool{
-		ctx: context.Background(),
-	}
+	dbp := DBPool{}
 
-	if dbp.pool, err = pgxpool.NewWithConfig(dbp.ctx, dbcfg); err != nil {
-		return err
+	if dbp.pool, err = pgxpool.NewWithConfig(ctx, dbcfg); err != nil {
+		return nil, err
 	}
 
-	conn, err := dbp.pool.Acquire(dbp.ctx)
+	conn, err := dbp.pool.Acquire(ctx)
 	if err != nil {
-		return err
+		dbp.pool.Close()
+		return nil, err
 	}
 	defer conn.Release()
 
-	Pool = &dbp
-	return nil
+	return &dbp, nil
+}
+
+// Close closes all the connections of the pool
+func (p *DBPool) Close() {
+	p.pool.Close()
 }
 
 func afterConn(ctx context.Context, dbc *pgx.Conn) error {
@@ -82,29 +211,62 @@ func afterConn(ctx context.Context, dbc *pgx.Conn) error {
 	return nil
 }
 
-func (p *DBPool) AddSurvey(m *ds.Survey) (err error) {
-	conn, err := p.pool.Acquire(p.ctx)
+func (p *DBPool) AddSurvey(ctx context.Context, m *ds.Survey) (err error) {
+	conn, err := p.pool.Acquire(ctx)
 	if err != nil {
 		return err
 	}
 	defer conn.Release()
-	tx, err := conn.Begin(p.ctx)
+	tx, err := conn.Begin(ctx)
 	if err != nil {
 		return err
 	}
 	defer func() {
 		if err != nil {
-			tx.Rollback(p.ctx)
+			tx.Rollback(ctx)
 			return
 		}
-		if tx.Commit(p.ctx) != nil {
-			tx.Rollback(p.ctx)
+		if tx.Commit(ctx) != nil {
+			tx.Rollback(ctx)
 		}
 	}()
 
+	return addSurvey(ctx, tx, m)
+}
+
+// addSurvey inserts the survey with its points in the transaction
+func addSurvey(ctx context.Context, tx pgx.Tx, m *ds.Survey) (err error) {
 	var rows pgx.Rows
 
-	if rows, err = tx.Query(p.ctx, "addsheetsurvey",	m.CMDR, m.Project);  err != nil {
+	sflags := m.Flags
+	if sflags == nil {
+		sflags = []string{}
+	}
+	var respondent *string
+	if m.Respondent != "" {
+		respondent = &m.Respondent
+	}
+	var rawtabid *int
+	if m.ArchiveID != 0 {
+		rawtabid = &m.ArchiveID
+	}
+	// the parser rejects these, a clear error rather than the function's
+	if m.CMDR == "" || m.Project == "" {
+		merr := &ds.MetadataError{
+			SpreadsheetID: m.SpreadsheetID,
+			Tab: m.Name,
+		}
+		if m.CMDR == "" {
+			merr.Fields = append(merr.Fields, "cmdr")
+		}
+		if m.Project == "" {
+			merr.Fields = append(merr.Fields, "project")
+		}
+		return merr
+	}
+	if rows, err = tx.Query(ctx, "addsheetsurvey",	m.CMDR, m.Project, sflags, m.Submitted, respondent,
+		rawtabid, nullString(m.SpreadsheetID), nullString(m.Name), m.Date, nullString(m.Notes),
+		nullString(m.Source));  err != nil {
 		return err
 	}
 
@@ -125,8 +287,13 @@ func (p *DBPool) AddSurvey(m *ds.Survey) (err error) {
 	rows.Close()
 
 	for _, dp := range m.SurveyPoints {
-		if _, err = tx.Exec(p.ctx, "addsurveypoint", mid, dp.SystemName, dp.ZSample,
-			dp.X, dp.Y, dp.Z, dp.Count, dp.MaxDistance); err != nil {
+		flags := dp.Flags
+		if flags == nil {
+			flags = []string{}
+		}
+		x, y, z, source := nullCoordinates(&dp)
+		if _, err = tx.Exec(ctx, "addsurveypoint", mid, dp.SystemName, dp.ZSample,
+			x, y, z, dp.Count, dp.MaxDistance, flags, source); err != nil {
 			return errors.Join(err, fmt.Errorf("Error while inserting surveypoint"))
 		}
 	}
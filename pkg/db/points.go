@@ -0,0 +1,178 @@
+package db
+
+import (
+	"fmt"
+	"errors"
+	"context"
+	"strings"
+
+	ds "github.com/gczuczy/dw-stellar-density-analyzer/pkg/densitysurvey"
+)
+
+// nullCoordinates returns the coordinates to store, NULLs for unresolved points
+func nullCoordinates(dp *ds.SurveyPoint) (x, y, z *float32, source *string) {
+	if !dp.Resolved {
+		return nil, nil, nil, nil
+	}
+	return &dp.X, &dp.Y, &dp.Z, &dp.CoordSource
+}
+
+// Resolve implements densitysurvey.CoordinateResolver with the systems
+// already resolved in earlier surveys
+func (p *DBPool) Resolve(ctx context.Context, names []string) (map[string]ds.Coordinates, error) {
+	ret := map[string]ds.Coordinates{}
+
+	lnames := make([]string, 0, len(names))
+	for _, name := range names {
+		lnames = append(lnames, strings.ToLower(name))
+	}
+
+	rows, err := p.pool.Query(ctx, "resolvecached", lnames)
+	if err != nil {
+		return ret, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			name string
+			c ds.Coordinates
+		)
+		if err = rows.Scan(&name, &c.X, &c.Y, &c.Z); err != nil {
+			return ret, err
+		}
+		c.Source = ds.CoordSourceCache
+		ret[name] = c
+	}
+
+	return ret, rows.Err()
+}
+
+// UnresolvedSurveys returns the IDs of the surveys having unresolved points,
+// the ones tried the least recently first
+func (p *DBPool) UnresolvedSurveys(ctx context.Context, limit int) ([]int, error) {
+	ret := []int{}
+
+	rows, err := p.pool.Query(ctx, "unresolvedsurveys", limit)
+	if err != nil {
+		return ret, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			return ret, err
+		}
+		ret = append(ret, id)
+	}
+
+	return ret, rows.Err()
+}
+
+// SurveyPoints loads the stored points of a survey
+func (p *DBPool) SurveyPoints(ctx context.Context, surveyid int) ([]ds.SurveyPoint, error) {
+	ret := []ds.SurveyPoint{}
+
+	rows, err := p.pool.Query(ctx, "surveypoints", surveyid)
+	if err != nil {
+		return ret, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			dp ds.SurveyPoint
+			x, y, z *float32
+			source *string
+		)
+		if err = rows.Scan(&dp.SystemName, &dp.ZSample, &x, &y, &z, &source, &dp.Count,
+			&dp.MaxDistance, &dp.Flags); err != nil {
+			return ret, err
+		}
+		if x != nil {
+			c := ds.Coordinates{X: *x, Y: *y, Z: *z}
+			if source != nil {
+				c.Source = *source
+			}
+			dp.SetCoordinates(c)
+			// so it's cross-checked once resolved
+			if c.Source == ds.CoordSourceSheet {
+				dp.SheetCoordinates = &c
+			}
+		}
+		ret = append(ret, dp)
+	}
+
+	return ret, rows.Err()
+}
+
+// UpdateSurveyPoints stores the coordinates and the flags of the points of
+// a survey in place, and records the resolution attempt on the ones still
+// unresolved or only having the sheet's coordinates
+func (p *DBPool) UpdateSurveyPoints(ctx context.Context, surveyid int, points []ds.SurveyPoint) (err error) {
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback(ctx)
+			return
+		}
+		err = tx.Commit(ctx)
+	}()
+
+	for _, dp := range points {
+		flags := dp.Flags
+		if flags == nil {
+			flags = []string{}
+		}
+		x, y, z, source := nullCoordinates(&dp)
+		if _, err = tx.Exec(ctx, "updatesurveypoint", surveyid, dp.SystemName,
+			x, y, z, source, flags); err != nil {
+			return errors.Join(err, fmt.Errorf("Error while updating %d/%s", surveyid, dp.SystemName))
+		}
+	}
+
+	if _, err = tx.Exec(ctx, "markresolveattempt", surveyid); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// LatestSurveys returns the points of the latest survey of every tab of the
+// spreadsheet, keyed by the tab
+func (p *DBPool) LatestSurveys(ctx context.Context, spreadsheetid string) (map[string][]ds.SurveyPoint, error) {
+	ret := map[string][]ds.SurveyPoint{}
+
+	rows, err := p.pool.Query(ctx, "latestsurveys", spreadsheetid)
+	if err != nil {
+		return ret, err
+	}
+	ids := map[string]int{}
+	for rows.Next() {
+		var (
+			tab string
+			id int
+		)
+		if err = rows.Scan(&tab, &id); err != nil {
+			rows.Close()
+			return ret, err
+		}
+		ids[tab] = id
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return ret, err
+	}
+
+	for tab, id := range ids {
+		if ret[tab], err = p.SurveyPoints(ctx, id); err != nil {
+			return ret, err
+		}
+	}
+
+	return ret, nil
+}
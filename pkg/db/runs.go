@@ -0,0 +1,127 @@
+package db
+
+import (
+	"context"
+
+	ds "github.com/gczuczy/dw-stellar-density-analyzer/pkg/densitysurvey"
+)
+
+// RecordRun records an ingest run: the sources it listed completely, and
+// per source the spreadsheets seen with the tabs of the ones read (nil for
+// the others). Then it retracts the surveys which disappeared since the
+// previous run of the complete sources. Returns the number of surveys
+// retracted.
+func (p *DBPool) RecordRun(ctx context.Context, complete []string,
+	seen map[string]map[string][]string) (n int, err error) {
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback(ctx)
+			return
+		}
+		err = tx.Commit(ctx)
+	}()
+
+	var runid int
+	if err = tx.QueryRow(ctx, "addrun").Scan(&runid); err != nil {
+		return 0, err
+	}
+	for _, source := range complete {
+		if _, err = tx.Exec(ctx, "addrunsource", runid, source); err != nil {
+			return 0, err
+		}
+	}
+	for source, sheets := range seen {
+		for sheetid, tabs := range sheets {
+			if _, err = tx.Exec(ctx, "addrunsheet", runid, source, sheetid, nil); err != nil {
+				return 0, err
+			}
+			for _, tab := range tabs {
+				if _, err = tx.Exec(ctx, "addrunsheet", runid, source, sheetid, tab); err != nil {
+					return 0, err
+				}
+			}
+		}
+	}
+
+	err = tx.QueryRow(ctx, "retract", runid).Scan(&n)
+	return n, err
+}
+
+// RetractedSurveys lists the retracted surveys of the spreadsheets, all of
+// them without any
+func (p *DBPool) RetractedSurveys(ctx context.Context, spreadsheetids []string) ([]ds.RetractedSurvey, error) {
+	ret := []ds.RetractedSurvey{}
+
+	rows, err := p.pool.Query(ctx, "retractedsurveys", nonNil(spreadsheetids))
+	if err != nil {
+		return ret, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			rs ds.RetractedSurvey
+			sheetid, tab *string
+		)
+		if err = rows.Scan(&rs.ID, &rs.CMDR, &rs.Project, &sheetid, &tab, &rs.Retracted); err != nil {
+			return ret, err
+		}
+		if sheetid != nil {
+			rs.SpreadsheetID = *sheetid
+		}
+		if tab != nil {
+			rs.Tab = *tab
+		}
+		ret = append(ret, rs)
+	}
+
+	return ret, rows.Err()
+}
+
+// RestoreSurveys clears the retraction of the spreadsheets' surveys, all of
+// them without any, and returns their number
+func (p *DBPool) RestoreSurveys(ctx context.Context, spreadsheetids []string) (int, error) {
+	tag, err := p.pool.Exec(ctx, "restoresurveys", nonNil(spreadsheetids))
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// PurgeSurveys deletes the retracted surveys of the spreadsheets, all of
+// them without any, and returns their number
+func (p *DBPool) PurgeSurveys(ctx context.Context, spreadsheetids []string) (n int, err error) {
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback(ctx)
+			return
+		}
+		err = tx.Commit(ctx)
+	}()
+
+	ids := nonNil(spreadsheetids)
+	if _, err = tx.Exec(ctx, "purgepoints", ids); err != nil {
+		return 0, err
+	}
+	tag, err := tx.Exec(ctx, "purgesurveys", ids)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// nonNil makes sure an empty list is passed as an empty array, not NULL
+func nonNil(ss []string) []string {
+	if ss == nil {
+		return []string{}
+	}
+	return ss
+}
@@ -0,0 +1,56 @@
+package densitysurvey
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// columnIndex converts a column letter (A, B, ..., AA) to the 0-based index
+func columnIndex(letters string) (int, error) {
+	letters = strings.ToUpper(strings.TrimSpace(letters))
+	if letters == "" {
+		return -1, fmt.Errorf("empty column")
+	}
+
+	idx := 0
+	for _, r := range letters {
+		if r < 'A' || r > 'Z' {
+			return -1, fmt.Errorf("invalid column '%s'", letters)
+		}
+		idx = idx*26 + int(r-'A'+1)
+	}
+	return idx - 1, nil
+}
+
+// columnLetters is the reverse of columnIndex
+func columnLetters(idx int) string {
+	letters := ""
+	for idx += 1; idx > 0; idx = (idx - 1) / 26 {
+		letters = string(rune('A'+(idx-1)%26)) + letters
+	}
+	return letters
+}
+
+// parseCell converts an A1 cell reference to 0-based row and column indexes
+func parseCell(ref string) (row int, col int, err error) {
+	ref = strings.ToUpper(strings.TrimSpace(ref))
+	i := strings.IndexAny(ref, "0123456789")
+	if i <= 0 {
+		return -1, -1, fmt.Errorf("invalid cell '%s'", ref)
+	}
+
+	if col, err = columnIndex(ref[:i]); err != nil {
+		return -1, -1, err
+	}
+	if row, err = strconv.Atoi(ref[i:]); err != nil || row < 1 {
+		return -1, -1, fmt.Errorf("invalid cell '%s'", ref)
+	}
+
+	return row - 1, col, nil
+}
+
+// cellRef is the A1 reference of 0-based row and column indexes
+func cellRef(row, col int) string {
+	return fmt.Sprintf("%s%d", columnLetters(col), row+1)
+}
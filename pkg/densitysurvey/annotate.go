@@ -0,0 +1,304 @@
+package densitysurvey
+
+import (
+	"fmt"
+	"math"
+	"errors"
+	"context"
+	"strings"
+
+	"google.golang.org/api/sheets/v4"
+
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/google"
+)
+
+const (
+	// our notes start with this, so they can be told apart from the CMDRs'
+	notePrefix = "[edsda] "
+)
+
+var (
+	// the explanations of the point flags in the notes
+	flagNotes = map[string]string{
+		FlagHeightDeviation: "the system's height deviates too much from the z-sample",
+		FlagColumnDrift: "the system is too far from the survey's column",
+		FlagCoordinateMismatch: "the coordinates differ from the resolved ones",
+		FlagOffSchedule: "the z-sample is not one of the expected heights",
+	}
+
+	issueColor = &sheets.Color{Red: 1, Green: 0.8, Blue: 0.8}
+)
+
+// the cell of an annotation
+type annotationCell struct {
+	sheetid int64
+	row int
+	column int
+}
+
+// the note and background of a cell before annotating it
+type cellState struct {
+	note string
+	background *sheets.Color
+}
+
+// Annotate attaches notes with the problems found to the offending cells of
+// the surveys' sheets and highlights them. The results are the outcome of
+// parsing the tabs, the rejected ones are annotated from them; the surveys
+// are the ones parsed successfully, after their validation. The CMDRs'
+// notes are kept, ours are appended to them, and only the cells without a
+// background of their own are highlighted. The annotations of the previous
+// runs which are not valid anymore are removed, the tabs not in the results
+// are left alone.
+func (ds *DensitySpreadsheet) Annotate(ctx context.Context, results []TabResult, surveys []Survey) error {
+	notes := map[annotationCell][]string{}
+	add := func(tab string, annotations map[annotationCell][]string) {
+		sheet := ds.spreadsheet.SheetByTitle(tab)
+		if sheet == nil {
+			return
+		}
+		for cell, msgs := range annotations {
+			cell.sheetid = sheet.Properties.SheetId
+			notes[cell] = append(notes[cell], msgs...)
+		}
+	}
+
+	tabs := []string{}
+	for _, res := range results {
+		tabs = append(tabs, res.Tab)
+		if res.Err != nil {
+			add(res.Tab, res.annotations())
+		}
+	}
+	for _, m := range surveys {
+		add(m.Name, m.annotations())
+	}
+
+	cells, err := ds.cellStates(ctx, tabs)
+	if err != nil {
+		return err
+	}
+
+	reqs := []*sheets.Request{}
+	for cell, st := range cells {
+		if _, ok := notes[cell]; ok || !isAnnotated(st.note) {
+			continue
+		}
+		reqs = append(reqs, annotationRequest(cell, cmdrNote(st.note), nil, isHighlighted(st.background)))
+	}
+	for cell, msgs := range notes {
+		st := cells[cell]
+		note := notePrefix + strings.Join(msgs, "\n")
+		if own := cmdrNote(st.note); own != "" {
+			note = own + "\n\n" + note
+		}
+		reqs = append(reqs, annotationRequest(cell, note, issueColor, isUnset(st.background)))
+	}
+
+	if len(reqs) == 0 {
+		return nil
+	}
+	_, err = ds.spreadsheet.BatchUpdate(ctx, reqs)
+	return err
+}
+
+// annotations collects the survey's problems per cell, the sheetid is
+// left for the caller to fill in
+func (m *Survey) annotations() map[annotationCell][]string {
+	ret := issueAnnotations(m.Issues)
+	add := func(row, col int, msg string) {
+		addAnnotation(ret, row, col, msg)
+	}
+
+	if m.variant == nil {
+		return ret
+	}
+	for _, dp := range m.SurveyPoints {
+		if !dp.Resolved {
+			add(dp.Row, m.variant.SysNameColumn, "the system could not be resolved")
+		}
+		for _, flag := range dp.Flags {
+			col := m.variant.SysNameColumn
+			switch flag {
+			case FlagHeightDeviation, FlagOffSchedule:
+				col = m.variant.ZSampleColumn
+			case FlagCoordinateMismatch:
+				if m.variant.XColumn >= 0 {
+					col = m.variant.XColumn
+				}
+			}
+			msg, ok := flagNotes[flag]
+			if !ok {
+				msg = flag
+			}
+			add(dp.Row, col, msg)
+		}
+	}
+
+	return ret
+}
+
+// annotations collects the problems of a rejected tab per cell: the cells
+// skipped, and the reason of the rejection if the tab is a survey. The
+// sheetid is left for the caller to fill in.
+func (res *TabResult) annotations() map[annotationCell][]string {
+	ret := issueAnnotations(res.Issues)
+
+	var (
+		cerr *CellError
+		merr *MetadataError
+		tferr *TooFewSamplesError
+	)
+	// the metadata error wraps the cell errors of the metadata cells
+	switch {
+	case errors.As(res.Err, &merr):
+		addAnnotation(ret, 0, 0, "survey metadata missing: "+strings.Join(merr.Fields, ", "))
+	case errors.As(res.Err, &tferr):
+		addAnnotation(ret, 0, 0, fmt.Sprintf("too few valid samples for the %s sheet, %d of %d rows",
+			tferr.Variant, tferr.Samples, tferr.Rows))
+	case errors.As(res.Err, &cerr):
+		if row, col, err := parseCell(cerr.Cell); err == nil {
+			addAnnotation(ret, row, col, cerr.Err.Error())
+		}
+	}
+
+	return ret
+}
+
+func issueAnnotations(issues []CellIssue) map[annotationCell][]string {
+	ret := map[annotationCell][]string{}
+	for _, issue := range issues {
+		msg := issue.Message
+		if issue.Err != nil {
+			msg = fmt.Sprintf("%s: %v", msg, issue.Err.Err)
+		}
+		addAnnotation(ret, issue.Row, issue.Column, msg)
+	}
+	return ret
+}
+
+func addAnnotation(annotations map[annotationCell][]string, row, col int, msg string) {
+	if row < 0 || col < 0 {
+		return
+	}
+	cell := annotationCell{row: row, column: col}
+	annotations[cell] = append(annotations[cell], msg)
+}
+
+// cellStates reads the notes and backgrounds of the tabs' cells having any,
+// limited to the columns the parser reads
+func (ds *DensitySpreadsheet) cellStates(ctx context.Context, tabs []string) (map[annotationCell]cellState, error) {
+	ret := map[annotationCell]cellState{}
+
+	ranges := []string{}
+	columns := ds.parser.columns()
+	for _, tab := range tabs {
+		sheet := ds.spreadsheet.SheetByTitle(tab)
+		if sheet == nil || sheet.Properties.GridProperties == nil {
+			continue
+		}
+		ncols := min(int64(columns), sheet.Properties.GridProperties.ColumnCount)
+		if ncols <= 0 {
+			continue
+		}
+		ranges = append(ranges, fmt.Sprintf("%s!A:%s", google.QuoteSheet(tab), columnLetters(int(ncols)-1)))
+	}
+	if len(ranges) == 0 {
+		return ret, nil
+	}
+
+	ss, err := ds.spreadsheet.GridDataRanges(ctx, ranges,
+		"sheets(properties(sheetId),data(startRow,startColumn,rowData(values(note,userEnteredFormat(backgroundColor)))))")
+	if err != nil {
+		return ret, err
+	}
+
+	for _, sheet := range ss.Sheets {
+		for _, grid := range sheet.Data {
+			for r, rd := range grid.RowData {
+				for c, cd := range rd.Values {
+					st := cellState{note: cd.Note}
+					if cd.UserEnteredFormat != nil {
+						st.background = cd.UserEnteredFormat.BackgroundColor
+					}
+					if st.note == "" && st.background == nil {
+						continue
+					}
+					ret[annotationCell{
+						sheetid: sheet.Properties.SheetId,
+						row: int(grid.StartRow) + r,
+						column: int(grid.StartColumn) + c,
+					}] = st
+				}
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+// isAnnotated tells whether the note has our part
+func isAnnotated(note string) bool {
+	return strings.HasPrefix(note, notePrefix) || strings.Contains(note, "\n"+notePrefix)
+}
+
+// cmdrNote is the note without our part
+func cmdrNote(note string) string {
+	if strings.HasPrefix(note, notePrefix) {
+		return ""
+	}
+	if i := strings.Index(note, "\n"+notePrefix); i >= 0 {
+		note = note[:i]
+	}
+	return strings.TrimRight(note, "\n")
+}
+
+// isUnset tells whether the background is the default one, so the
+// highlight can be removed without losing the template's colour
+func isUnset(c *sheets.Color) bool {
+	return c == nil || isHighlighted(c) || sameColor(c, &sheets.Color{Red: 1, Green: 1, Blue: 1})
+}
+
+// isHighlighted tells whether the background is our highlight
+func isHighlighted(c *sheets.Color) bool {
+	return c != nil && sameColor(c, issueColor)
+}
+
+// sameColor compares the colours, the API returns them rounded
+func sameColor(a, b *sheets.Color) bool {
+	const eps = 1.0 / 255
+	return math.Abs(a.Red-b.Red) < eps && math.Abs(a.Green-b.Green) < eps &&
+		math.Abs(a.Blue-b.Blue) < eps
+}
+
+// annotationRequest sets the note of a cell, and its background if
+// highlight is set, a nil background clears it
+func annotationRequest(cell annotationCell, note string, bg *sheets.Color, highlight bool) *sheets.Request {
+	data := &sheets.CellData{
+		Note: note,
+	}
+	fields := "note"
+	if highlight {
+		data.UserEnteredFormat = &sheets.CellFormat{
+			BackgroundColor: bg,
+		}
+		fields += ",userEnteredFormat.backgroundColor"
+	}
+	return &sheets.Request{
+		UpdateCells: &sheets.UpdateCellsRequest{
+			Range: &sheets.GridRange{
+				SheetId: cell.sheetid,
+				StartRowIndex: int64(cell.row),
+				EndRowIndex: int64(cell.row) + 1,
+				StartColumnIndex: int64(cell.column),
+				EndColumnIndex: int64(cell.column) + 1,
+			},
+			Rows: []*sheets.RowData{
+				&sheets.RowData{
+					Values: []*sheets.CellData{data},
+				},
+			},
+			Fields: fields,
+		},
+	}
+}
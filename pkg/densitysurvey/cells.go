@@ -0,0 +1,233 @@
+package densitysurvey
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"errors"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+var (
+	errEmptyCell = errors.New("empty cell")
+	errNotNumber = errors.New("not a number")
+	errNotInteger = errors.New("not an integer")
+	errAmbiguousNumber = errors.New("ambiguous number, the comma is read as a decimal separator")
+)
+
+// CellError is a cell which couldn't be converted to the expected type
+type CellError struct {
+	SpreadsheetID string
+	Tab string
+	// A1 reference
+	Cell string
+	// the value as read from the sheet
+	Raw interface{}
+	Err error
+}
+
+func (e *CellError) Error() string {
+	return fmt.Sprintf("%s/%s!%s '%v': %v", e.SpreadsheetID, e.Tab, e.Cell, e.Raw, e.Err)
+}
+
+func (e *CellError) Unwrap() error {
+	return e.Err
+}
+
+// cellGrid is a typed accessor of a sheet's unformatted values, it never
+// panics on missing or unexpectedly typed cells. Rows and columns are 0-based.
+type cellGrid struct {
+	spreadsheetID string
+	tab string
+	values [][]interface{}
+	// the cells read with an ambiguous comma, see parseNumber
+	ambiguous map[cellPos]bool
+}
+
+type cellPos struct {
+	row int
+	col int
+}
+
+func newCellGrid(spreadsheetID, tab string, vr *sheets.ValueRange) *cellGrid {
+	g := &cellGrid{
+		spreadsheetID: spreadsheetID,
+		tab: tab,
+	}
+	if vr != nil {
+		g.values = vr.Values
+	}
+	return g
+}
+
+// Rows is the number of rows having data
+func (g *cellGrid) Rows() int {
+	return len(g.values)
+}
+
+// Row is the raw values of a row, nil if it's out of the data
+func (g *cellGrid) Row(row int) []interface{} {
+	if row < 0 || row >= len(g.values) {
+		return nil
+	}
+	return g.values[row]
+}
+
+// Raw is the value of a cell, nil if it's out of the data
+func (g *cellGrid) Raw(row, col int) interface{} {
+	r := g.Row(row)
+	if col < 0 || col >= len(r) {
+		return nil
+	}
+	return r[col]
+}
+
+// String is the cell's value as text, numbers are formatted without
+// exponent, missing cells are empty
+func (g *cellGrid) String(row, col int) string {
+	switch v := g.Raw(row, col).(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// IsEmpty tells whether the cell is missing or blank
+func (g *cellGrid) IsEmpty(row, col int) bool {
+	return strings.TrimSpace(g.String(row, col)) == ""
+}
+
+// Float converts the cell to a number. Text is accepted with decimal
+// commas, thousands separators and percentages.
+func (g *cellGrid) Float(row, col int) (float64, error) {
+	raw := g.Raw(row, col)
+
+	var (
+		v float64
+		ambiguous bool
+		err error
+	)
+	switch rv := raw.(type) {
+	case nil:
+		err = errEmptyCell
+	case float64:
+		v = rv
+	case string:
+		v, ambiguous, err = parseNumber(rv)
+	default:
+		err = errNotNumber
+	}
+	if err == nil && (math.IsNaN(v) || math.IsInf(v, 0)) {
+		err = errNotNumber
+	}
+
+	if err != nil {
+		return 0, g.cellError(row, col, err)
+	}
+	if ambiguous {
+		if g.ambiguous == nil {
+			g.ambiguous = map[cellPos]bool{}
+		}
+		g.ambiguous[cellPos{row, col}] = true
+	}
+	return v, nil
+}
+
+// ambiguousCells is the cells read with an ambiguous comma since the last
+// call, in row and column order
+func (g *cellGrid) ambiguousCells() []cellPos {
+	ret := make([]cellPos, 0, len(g.ambiguous))
+	for p := range g.ambiguous {
+		ret = append(ret, p)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].row != ret[j].row {
+			return ret[i].row < ret[j].row
+		}
+		return ret[i].col < ret[j].col
+	})
+	g.ambiguous = nil
+	return ret
+}
+
+// Int converts the cell to an integer, it has to be a whole number
+func (g *cellGrid) Int(row, col int) (int, error) {
+	v, err := g.Float(row, col)
+	if err != nil {
+		return 0, err
+	}
+	if v != math.Trunc(v) || math.Abs(v) > math.MaxInt32 {
+		return 0, g.cellError(row, col, errNotInteger)
+	}
+	return int(v), nil
+}
+
+func (g *cellGrid) cellError(row, col int, err error) *CellError {
+	return &CellError{
+		SpreadsheetID: g.spreadsheetID,
+		Tab: g.tab,
+		Cell: cellRef(row, col),
+		Raw: g.Raw(row, col),
+		Err: err,
+	}
+}
+
+// parseNumber parses numbers as people type them: "12.5", "12,5",
+// "1,234.5", "1.234,5", "1,234,567", "-3 000", "+12", "45%". A single
+// comma is a decimal separator, when it's followed by exactly 3 digits it
+// could be a thousands separator too, that's reported as ambiguous.
+func parseNumber(s string) (v float64, ambiguous bool, err error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, false, errEmptyCell
+	}
+
+	scale := 1.0
+	if strings.HasSuffix(s, "%") {
+		s = strings.TrimSpace(strings.TrimSuffix(s, "%"))
+		scale = 0.01
+	}
+
+	// spaces as thousands separators
+	s = strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\u00a0' || r == '\u202f' || r == '\'' {
+			return -1
+		}
+		return r
+	}, s)
+
+	comma := strings.LastIndex(s, ",")
+	dot := strings.LastIndex(s, ".")
+	switch {
+	case comma >= 0 && dot >= 0:
+		// the last one is the decimal separator
+		if comma > dot {
+			s = strings.ReplaceAll(s, ".", "")
+			s = strings.Replace(s, ",", ".", 1)
+		} else {
+			s = strings.ReplaceAll(s, ",", "")
+		}
+	case comma >= 0:
+		// several commas can only be thousands separators
+		if strings.Count(s, ",") > 1 {
+			s = strings.ReplaceAll(s, ",", "")
+		} else {
+			// "0,125" can't be grouped
+			ambiguous = len(s)-comma-1 == 3 && strings.TrimLeft(s[:comma], "+-") != "0"
+			s = strings.Replace(s, ",", ".", 1)
+		}
+	}
+
+	if v, err = strconv.ParseFloat(s, 64); err != nil {
+		return 0, false, errNotNumber
+	}
+	return v * scale, ambiguous, nil
+}
@@ -0,0 +1,138 @@
+package densitysurvey
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		in string
+		want float64
+		ambiguous bool
+		err error
+	}{
+		{"12.5", 12.5, false, nil},
+		{"12,5", 12.5, false, nil},
+		{" -3 000 ", -3000, false, nil},
+		{"+12", 12, false, nil},
+		{"45%", 0.45, false, nil},
+		{"1'234", 1234, false, nil},
+		// the last separator is the decimal one
+		{"1,234.5", 1234.5, false, nil},
+		{"1.234,5", 1234.5, false, nil},
+		// several commas are grouping
+		{"1,234,567", 1234567, false, nil},
+		// a single comma is decimal, ambiguous with 3 digits after it
+		{"17,783", 17.783, true, nil},
+		{"-17,783", -17.783, true, nil},
+		{"0,125", 0.125, false, nil},
+		{"-0,125", -0.125, false, nil},
+		{"1,5", 1.5, false, nil},
+		{"1,2345", 1.2345, false, nil},
+		{"", 0, false, errEmptyCell},
+		{"  ", 0, false, errEmptyCell},
+		{"abc", 0, false, errNotNumber},
+		{"1,2,3.4.5", 0, false, errNotNumber},
+	}
+
+	for _, tt := range tests {
+		got, ambiguous, err := parseNumber(tt.in)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("parseNumber(%q) error %v, want %v", tt.in, err, tt.err)
+			continue
+		}
+		if got != tt.want || ambiguous != tt.ambiguous {
+			t.Errorf("parseNumber(%q) = %v, ambiguous %v, want %v, ambiguous %v", tt.in, got,
+				ambiguous, tt.want, tt.ambiguous)
+		}
+	}
+}
+
+func TestCellGrid(t *testing.T) {
+	g := testGrid([][]interface{}{
+		{"Z Sample", 12.0, "3,5", "17,783", nil, "x", true},
+		{2.5, "1e3", "NaN", "", "12", 3e9},
+	})
+
+	floats := []struct {
+		row, col int
+		want float64
+		err error
+	}{
+		{0, 1, 12, nil},
+		{0, 2, 3.5, nil},
+		{0, 3, 17.783, nil},
+		{0, 4, 0, errEmptyCell},
+		{0, 5, 0, errNotNumber},
+		{0, 6, 0, errNotNumber},
+		{0, 10, 0, errEmptyCell},
+		{5, 0, 0, errEmptyCell},
+		{1, 1, 1000, nil},
+		{1, 2, 0, errNotNumber},
+	}
+	for _, tt := range floats {
+		got, err := g.Float(tt.row, tt.col)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("Float(%s) = %v, %v, want %v, %v", cellRef(tt.row, tt.col), got, err, tt.want, tt.err)
+		}
+		var cerr *CellError
+		if err != nil && (!errors.As(err, &cerr) || cerr.Cell != cellRef(tt.row, tt.col)) {
+			t.Errorf("Float(%s) error %v is not a CellError of the cell", cellRef(tt.row, tt.col), err)
+		}
+	}
+
+	ints := []struct {
+		row, col int
+		want int
+		err error
+	}{
+		{0, 1, 12, nil},
+		{1, 4, 12, nil},
+		{1, 0, 0, errNotInteger},
+		{1, 5, 0, errNotInteger},
+		{0, 0, 0, errNotNumber},
+	}
+	for _, tt := range ints {
+		got, err := g.Int(tt.row, tt.col)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("Int(%s) = %v, %v, want %v, %v", cellRef(tt.row, tt.col), got, err, tt.want, tt.err)
+		}
+	}
+
+	if g.String(1, 0) != "2.5" || g.String(0, 4) != "" || !g.IsEmpty(1, 3) {
+		t.Errorf("String/IsEmpty")
+	}
+}
+
+func TestAmbiguousCells(t *testing.T) {
+	g := testGrid([][]interface{}{
+		{"1,500", "2,5"},
+		{"3,000", "4,250"},
+	})
+	for _, c := range [][2]int{{1, 1}, {0, 0}, {0, 1}, {1, 0}, {0, 0}} {
+		g.Float(c[0], c[1])
+	}
+
+	got := g.ambiguousCells()
+	want := []cellPos{{0, 0}, {1, 0}, {1, 1}}
+	if len(got) != len(want) {
+		t.Fatalf("ambiguous cells %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("ambiguous cells %v, want %v", got, want)
+			break
+		}
+	}
+	if again := g.ambiguousCells(); len(again) != 0 {
+		t.Errorf("ambiguous cells not reset: %v", again)
+	}
+
+	m := Survey{}
+	g.Float(0, 0)
+	m.addAmbiguousCells(g)
+	if len(m.Issues) != 1 || m.Issues[0].Err == nil || !errors.Is(m.Issues[0].Err, errAmbiguousNumber) {
+		t.Errorf("issues %v, want the ambiguous A1", m.Issues)
+	}
+}
@@ -0,0 +1,78 @@
+package densitysurvey
+
+import (
+	"math"
+)
+
+// the same clamping as density.v_surveypoints does
+func clamp(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(v, hi))
+}
+
+// Density is the stellar density around the point in systems/ly^3, with
+// the 1-sigma confidence bounds of the system count as a Poisson variable
+// (Gehrels' approximations)
+func (dp *SurveyPoint) Density() (rho float64, lower float64, upper float64) {
+	n := clamp(float64(dp.Count), 1, 50)
+	r := clamp(float64(dp.MaxDistance), 1, 20)
+	volume := (4 * math.Pi / 3) * math.Pow(r, 3)
+
+	upper = (n + 1) * math.Pow(1-1/(9*(n+1))+1/(3*math.Sqrt(n+1)), 3)
+	lower = n * math.Pow(1-1/(9*n)-1/(3*math.Sqrt(n)), 3)
+
+	return n / volume, lower / volume, upper / volume
+}
+
+// DensityFit is the exponential disk fit of a survey's density profile,
+// rho(z) = Rho0 * exp(-|z|/ScaleHeight). Same as density.v_surveyprofiles
+type DensityFit struct {
+	Points int
+	Rho0 float64
+	// NaN when the density doesn't decrease with the height
+	ScaleHeight float64
+	R2 float64
+}
+
+// FitProfile fits ln(rho) linearly on |z| over the resolved points, false
+// when there aren't enough of them
+func (m *Survey) FitProfile() (DensityFit, bool) {
+	var sx, sy, sxx, syy, sxy, n float64
+
+	for _, dp := range m.SurveyPoints {
+		if !dp.Resolved {
+			continue
+		}
+		rho, _, _ := dp.Density()
+		x := math.Abs(float64(dp.ZSample))
+		y := math.Log(rho)
+		sx += x
+		sy += y
+		sxx += x * x
+		syy += y * y
+		sxy += x * y
+		n += 1
+	}
+
+	fit := DensityFit{
+		Points: int(n),
+		ScaleHeight: math.NaN(),
+	}
+	vx := n*sxx - sx*sx
+	if n < 2 || vx == 0 {
+		return fit, false
+	}
+
+	slope := (n*sxy - sx*sy) / vx
+	intercept := (sy - slope*sx) / n
+	fit.Rho0 = math.Exp(intercept)
+	if slope < 0 {
+		fit.ScaleHeight = -1 / slope
+	}
+	if vy := n*syy - sy*sy; vy > 0 {
+		fit.R2 = (n*sxy - sx*sy) * (n*sxy - sx*sy) / (vx * vy)
+	} else {
+		fit.R2 = 1
+	}
+
+	return fit, true
+}
@@ -4,172 +4,439 @@ import (
 	"fmt"
 	"time"
 	"errors"
+	"context"
 	"strings"
-	"strconv"
 
 	"google.golang.org/api/sheets/v4"
 	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/google"
 )
 
-const (
-	MaxSamples = 256
-)
+// SheetSource opens spreadsheets, see google.GSpreadsheetsService
+type SheetSource interface {
+	Sheet(ctx context.Context, id string) (*google.GSpreadsheet, error)
+}
 
 type DensitySpreadsheet struct {
 	spreadsheet *google.GSpreadsheet
+	parser *Parser
+	// overriding the sheets' A1 when set
+	cmdr string
+	project string
 }
 
-func NewDensitySpreadsheet(sheetid string, ss *google.GSpreadsheetsService) (*DensitySpreadsheet, error) {
-	var (
-		s *google.GSpreadsheet
-		err error
-	)
-	f := func() (*google.GSpreadsheet, error) {
-		return ss.Sheet(sheetid)
-	}
-
-	s, err = google.RateLimit(f, 30 * time.Second)
+func NewDensitySpreadsheet(ctx context.Context, sheetid string, ss SheetSource, parser *Parser) (*DensitySpreadsheet, error) {
+	s, err := ss.Sheet(ctx, sheetid)
 	if err != nil {
 		return nil, errors.Join(err, fmt.Errorf("Unable to load sheet %s", sheetid))
 	}
 
 	return &DensitySpreadsheet{
 		spreadsheet: s,
+		parser: parser,
 	}, nil
 }
 
-func (ds *DensitySpreadsheet) GetSurveys() ([]Survey, error) {
+// ID is the ID of the spreadsheet
+func (ds *DensitySpreadsheet) ID() string {
+	return ds.spreadsheet.ID
+}
+
+// Override sets the CMDR and the project (campaign) of all the surveys,
+// instead of their A1 cell. Empty values keep the sheets' ones.
+func (ds *DensitySpreadsheet) Override(cmdr, project string) {
+	ds.cmdr = strings.TrimSpace(cmdr)
+	ds.project = strings.TrimSpace(project)
+}
+
+// TabResult is the outcome of parsing a sheet (tab), either the Survey or
+// the Err is set
+type TabResult struct {
+	Tab string
+	Survey *Survey
+	Err error
+	// the problems with the cells found before the tab was rejected
+	Issues []CellIssue
+}
+
+// GetSurveys parses all the sheets, and returns the surveys parsed
+// successfully. The error is the joined errors of the rest of the sheets,
+// see Results for the per-sheet outcome.
+func (ds *DensitySpreadsheet) GetSurveys(ctx context.Context) ([]Survey, error) {
 	var reterr error = nil
 	ret := []Survey{}
 
-	for _, sheet := range ds.spreadsheet.GetSheets() {
-		if m, err := ds.parseSheet(sheet.Properties.Title); err != nil {
-			//fmt.Printf("Sheet errors: %v\n", err)
-			err = errors.Join(reterr, err)
+	results, err := ds.Results(ctx)
+	if err != nil {
+		return ret, err
+	}
+
+	for _, res := range results {
+		if res.Err != nil {
+			reterr = errors.Join(reterr, res.Err)
 		} else {
-			ret = append(ret, m)
+			ret = append(ret, *res.Survey)
 		}
 	}
 
 	return ret, reterr
 }
 
-func (ds *DensitySpreadsheet) parseSheet(name string) (Survey, error) {
-	m := Survey{
-		Name: name,
-		SurveyPoints: make([]SurveyPoint, 0, 32),
+// RawTab is the values of a sheet (tab) as read, for archiving and
+// parsing them again later
+type RawTab struct {
+	// the ID in the archive, 0 if it's not archived
+	ID int
+	SpreadsheetID string
+	// the spreadsheet's title
+	Title string
+	Tab string
+	Fetched time.Time
+	// the spreadsheet's revision, empty if unknown
+	Revision string
+	// the overrides in effect
+	CMDR string
+	Project string
+	// the configured source it was ingested from, empty for the default one
+	Source string
+	Values *sheets.ValueRange
+}
+
+// Results parses all the sheets and returns the outcome for each of them,
+// the error is only set when the spreadsheet couldn't be read at all
+func (ds *DensitySpreadsheet) Results(ctx context.Context) ([]TabResult, error) {
+	tabs, err := ds.Fetch(ctx)
+	if err != nil {
+		return []TabResult{}, err
 	}
+	return ds.parser.Parse(tabs), nil
+}
 
-	endcell := fmt.Sprintf("I%d", MaxSamples)
+// Fetch reads the values of the sheets, in their order
+func (ds *DensitySpreadsheet) Fetch(ctx context.Context) ([]RawTab, error) {
+	ret := []RawTab{}
 
-	// get the cmdrname and project
-	data, err := ds.spreadsheet.ReadRange(name, "A1", endcell)
+	data, err := ds.readSheets(ctx)
 	if err != nil {
-		return m, err
+		return ret, err
+	}
+
+	now := time.Now()
+	title := ""
+	if props := ds.spreadsheet.Sheet.Properties; props != nil {
+		title = props.Title
+	}
+	for _, sheet := range ds.spreadsheet.GetSheets() {
+		tab := sheet.Properties.Title
+		vr, ok := data[tab]
+		if !ok {
+			continue
+		}
+		ret = append(ret, RawTab{
+			SpreadsheetID: ds.spreadsheet.ID,
+			Title: title,
+			Tab: tab,
+			Fetched: now,
+			CMDR: ds.cmdr,
+			Project: ds.project,
+			Values: vr,
+		})
 	}
-	parts := strings.Split(data.Values[0][0].(string), " - ")
-	if len(parts) == 2 {
-		m.CMDR = parts[0]
-		m.Project = parts[1]
+
+	return ret, nil
+}
+
+// Parse parses the tabs, the results sheets written by the cli are left
+// out. The surveys are linked to their archived tab.
+func (p *Parser) Parse(tabs []RawTab) []TabResult {
+	ret := []TabResult{}
+
+	for i := range tabs {
+		t := &tabs[i]
+		m, _, err := p.parseTab(t)
+		if errors.Is(err, errResultsSheet) {
+			continue
+		}
+		res := TabResult{
+			Tab: t.Tab,
+			Err: err,
+		}
+		if err == nil {
+			m.ArchiveID = t.ID
+			res.Survey = &m
+		} else {
+			res.Issues = m.Issues
+		}
+		ret = append(ret, res)
 	}
 
-	// identify the sheet type
-	var variant *sheetVariant = nil
-	for _, sv := range sheetVariants {
-		if evalSheetVariant(sv, data) {
-			variant = sv
-			break
+	return ret
+}
+
+// readSheets reads the values of all the grid sheets in one batch, keyed
+// by the sheet titles. The ranges are sized by the sheets' grid, the
+// columns are limited to what the variants use.
+func (ds *DensitySpreadsheet) readSheets(ctx context.Context) (map[string]*sheets.ValueRange, error) {
+	ret := map[string]*sheets.ValueRange{}
+
+	titles := []string{}
+	ranges := []string{}
+	columns := ds.parser.columns()
+	for _, sheet := range ds.spreadsheet.GetSheets() {
+		props := sheet.Properties
+		if props.SheetType != "GRID" || props.GridProperties == nil || props.GridProperties.RowCount == 0 {
+			continue
+		}
+		ncols := min(int64(columns), props.GridProperties.ColumnCount)
+		if ncols <= 0 {
+			continue
 		}
+		titles = append(titles, props.Title)
+		ranges = append(ranges, fmt.Sprintf("%s!A1:%s", google.QuoteSheet(props.Title),
+			cellRef(int(props.GridProperties.RowCount)-1, int(ncols)-1)))
 	}
+	if len(ranges) == 0 {
+		return ret, nil
+	}
+
+	vrs, err := ds.spreadsheet.BatchReadRanges(ctx, ranges)
+	if err != nil {
+		return ret, err
+	}
+	for i, vr := range vrs {
+		ret[titles[i]] = vr
+	}
+
+	return ret, nil
+}
+
+// parseTab parses a tab, returning the variants tried as well. The CMDR
+// and the project of the tab (the entry sheet's) override the sheet's
+// metadata, the source's campaign is the last resort.
+func (p *Parser) parseTab(t *RawTab) (Survey, []VariantCheck, error) {
+	spreadsheetID, name := t.SpreadsheetID, t.Tab
+	m := Survey{
+		Name: name,
+		SpreadsheetID: spreadsheetID,
+		Source: t.Source,
+		SurveyPoints: make([]SurveyPoint, 0, 32),
+		CMDR: strings.TrimSpace(t.CMDR),
+		Project: strings.TrimSpace(t.Project),
+	}
+	g := newCellGrid(spreadsheetID, name, t.Values)
+
+	if g.String(0, 0) == resultsMarker {
+		return m, nil, errResultsSheet
+	}
+	// get the cmdrname and project
+	tried := p.meta.extract(&m, g, t)
+	if m.Project == "" && p.project != "" {
+		m.Project = p.project
+	}
+
+	// identify the sheet type
+	variant, checks := p.identify(g, m.Project)
 	if variant == nil {
-		//fmt.Printf("Unable to identify sheet variant for %s/%s\n",
-		//	ds.spreadsheet.ID, name)
-		return m, fmt.Errorf("Unable to identify sheet variant for %s/%s",
-			ds.spreadsheet.ID, name)
+		var rejected error = nil
+		for _, vc := range checks {
+			rejected = errors.Join(rejected, vc.err)
+		}
+		return m, checks, &UnknownVariantError{
+			SpreadsheetID: spreadsheetID,
+			Tab: name,
+			Cause: rejected,
+		}
 	}
+	m.Variant = variant.Name
 
-	var (
-		z int
-		c int
-		mdstr string
-		md float64
-	)
-	for i := variant.HeaderRow+1; i < len(data.Values); i += 1 {
-		row := data.Values[i]
+	if m.CMDR == "" || m.Project == "" {
+		merr := &MetadataError{
+			SpreadsheetID: spreadsheetID,
+			Tab: name,
+			Cause: errors.Join(tried, errNoOverride),
+		}
+		if m.CMDR == "" {
+			merr.Fields = append(merr.Fields, "cmdr")
+		}
+		if m.Project == "" {
+			merr.Fields = append(merr.Fields, "project")
+		}
+		return m, checks, merr
+	}
 
+	// only the survey's own cells are reported, not the ones tried by the variants
+	g.ambiguousCells()
+	for i := variant.HeaderRow+1; i < variant.rowLimit(g.Rows()); i += 1 {
 		// if the ZSample is empty, bailout, that's the end of the road
-		if len(row) <= variant.ZSampleColumn || row[variant.ZSampleColumn].(string) == "" {
+		if g.IsEmpty(i, variant.ZSampleColumn) {
 			break
 		}
 
-		if z, err = strconv.Atoi(row[variant.ZSampleColumn].(string)); err != nil {
+		z, err := g.Float(i, variant.ZSampleColumn)
+		if err != nil {
 			// skip
+			m.addCellIssue(i, variant.ZSampleColumn, "row skipped, invalid z-sample", err)
 			continue
-			//return m, errors.Join(err, fmt.Errorf("Conversion ZSample error '%v': %s/%s",
-			//row[variant.ZSampleColumn], ds.spreadsheet.ID, name))
 		}
-		if c, err = strconv.Atoi(row[variant.SystemCountColumn].(string)); err != nil {
+		c, err := g.Int(i, variant.SystemCountColumn)
+		if err != nil {
 			// skip
+			m.addCellIssue(i, variant.SystemCountColumn, "row skipped, invalid system count", err)
 			continue
-			//return m, errors.Join(err, fmt.Errorf("Conversion SysCnt error '%v': %s/%s",
-			//	row[variant.SystemCountColumn], ds.spreadsheet.ID, name))
-		}
-		//fmt.Printf("%s/%s/r%d: %+v\n", ds.spreadsheet.ID, name, i, row)
-		if len(row) <= variant.MaxDistanceColumn {
-			mdstr = ""
-		} else {
-			mdstr = row[variant.MaxDistanceColumn].(string)
 		}
-		if mdstr == "" {
-			md = 20.0
-		} else if md, err = strconv.ParseFloat(mdstr, 32); err != nil {
-			return m, errors.Join(err, fmt.Errorf("Conversion MaxDst error %d/%d '%v': %s/%s",
-				i, variant.MaxDistanceColumn,
-				row[variant.MaxDistanceColumn], ds.spreadsheet.ID, name))
+		md := float64(variant.DefaultMaxDistance)
+		if variant.MaxDistanceColumn >= 0 && !g.IsEmpty(i, variant.MaxDistanceColumn) {
+			if md, err = g.Float(i, variant.MaxDistanceColumn); err != nil {
+				// skip
+				m.addCellIssue(i, variant.MaxDistanceColumn, "row skipped, invalid max distance", err)
+				continue
+			}
 		}
 		dp := SurveyPoint{
-			SystemName: row[variant.SysNameColumn].(string),
-			ZSample: z,
+			SystemName: strings.TrimSpace(g.String(i, variant.SysNameColumn)),
+			ZSample: float32(z),
 			Count: c,
 			MaxDistance: float32(md),
+			SheetCoordinates: sheetCoordinates(variant, g, i),
+			Row: i,
 		}
 		m.SurveyPoints = append(m.SurveyPoints, dp)
 	}
+	m.addAmbiguousCells(g)
+	m.schedule = variant.Schedule
+	if p.schedule != nil {
+		m.schedule = p.schedule
+	}
+	m.variant = variant
 
-	return m, nil
+	return m, checks, nil
 }
 
-func evalSheetVariant(sv *sheetVariant, data *sheets.ValueRange) bool {
+// identify finds the variant of the sheet: the variants are tried in
+// order, then the detection. Returns nil if none of them matched, the
+// checks are the ones tried.
+func (p *Parser) identify(g *cellGrid, project string) (*sheetVariant, []VariantCheck) {
+	checks := []VariantCheck{}
 
-	for _, check := range sv.HeaderChecks {
-		if len(data.Values) <= check.Row {
-			//fmt.Printf("(%s) Not enough rows has:%d check:%d\n", sv.Name,
-			//	len(data.Values), check.Row)
-			return false
+	for _, sv := range p.variants {
+		err := checkSheetVariant(sv, g, project)
+		checks = append(checks, newVariantCheck(sv, g, err))
+		if err == nil {
+			return sv, checks
 		}
-		if len(data.Values[check.Row]) <= check.Column {
-			//fmt.Printf("(%s) Not enough columns has:%d check:%d\n  %+v\n", sv.Name,
-			//	len(data.Values[check.Row]), check.Column, data.Values[check.Row])
-			return false
+	}
+
+	// fall back to finding the columns by their headers
+	if !p.detect.Enabled {
+		return nil, checks
+	}
+	sv, err := p.detectVariant(g)
+	if sv == nil {
+		checks = append(checks, VariantCheck{
+			Variant: detectedVariant,
+			Reason: err.Error(),
+			Rows: -1,
+			Samples: -1,
+			MinSampleRatio: p.detect.MinSampleRatio,
+			err: err,
+		})
+		return nil, checks
+	}
+	checks = append(checks, newVariantCheck(sv, g, nil))
+	return sv, checks
+}
+
+// sheetCoordinates reads the X/Z/Y columns of a row, nil if any of them
+// is missing or not a number. The sheets are using the game's orientation,
+// where Y is the vertical axis.
+func sheetCoordinates(sv *sheetVariant, g *cellGrid, row int) *Coordinates {
+	var xyz [3]float64
+
+	for i, col := range []int{sv.XColumn, sv.YColumn, sv.ZColumn} {
+		if col < 0 {
+			return nil
 		}
-		value := data.Values[check.Row][check.Column].(string)
+		v, err := g.Float(row, col)
+		if err != nil {
+			return nil
+		}
+		xyz[i] = v
+	}
+
+	return &Coordinates{
+		X: float32(xyz[0]),
+		Y: float32(xyz[2]),
+		Z: float32(xyz[1]),
+		Source: CoordSourceSheet,
+	}
+}
+
+// columns is the number of columns covering all the variants
+func (p *Parser) columns() int {
+	lastcol := 0
+	if p.detect.Enabled {
+		lastcol = detectColumns - 1
+	}
+	for _, sv := range p.variants {
+		lastcol = max(lastcol, sv.lastColumn())
+	}
+	lastcol = max(lastcol, p.meta.lastColumn())
+	return lastcol + 1
+}
+
+// checkSheetVariant tells whether the sheet of the project is of the
+// variant, nil if it is, otherwise a *HeaderMismatchError or a
+// *TooFewSamplesError
+func checkSheetVariant(sv *sheetVariant, g *cellGrid, project string) error {
+
+	// variants bound to a project
+	if sv.Project != "" {
+		if !strings.EqualFold(strings.TrimSpace(project), sv.Project) {
+			return &HeaderMismatchError{
+				SpreadsheetID: g.spreadsheetID,
+				Tab: g.tab,
+				Variant: sv.Name,
+				Cell: "A1",
+				Expected: "CMDR - " + sv.Project,
+				Got: g.String(0, 0),
+			}
+		}
+	}
+
+	for _, check := range sv.HeaderChecks {
+		value := g.String(check.Row, check.Column)
 		if value != check.Value {
-			//fmt.Printf("(%s) Does not match: '%s' VS '%s'\n", sv.Name, value, check.Value)
-			return false
+			return &HeaderMismatchError{
+				SpreadsheetID: g.spreadsheetID,
+				Tab: g.tab,
+				Variant: sv.Name,
+				Cell: cellRef(check.Row, check.Column),
+				Expected: check.Value,
+				Got: value,
+			}
 		}
 	}
 
+	nzsamples, nsamples := sampleCounts(sv, g)
+	if float32(nzsamples) * sv.MinSampleRatio < float32(nsamples) {
+		return nil
+	}
+	return &TooFewSamplesError{
+		SpreadsheetID: g.spreadsheetID,
+		Tab: g.tab,
+		Variant: sv.Name,
+		Rows: nzsamples,
+		Samples: nsamples,
+		MinSampleRatio: sv.MinSampleRatio,
+	}
+}
+
+// sampleCounts is the number of rows with a z-sample, and the ones of them
+// being valid samples in the variant's columns
+func sampleCounts(sv *sheetVariant, g *cellGrid) (nzsamples int, nsamples int) {
 	// check data validity, system names should be filled in the Z Sample col
-	nsamples := 0
-	nzsamples := 0
-	for i := sv.HeaderRow+1; i < len(data.Values); i+=1 {
-		var (
-			zstr, sysstr string
-			ok bool
-		)
-		row := data.Values[i]
+	for i := sv.HeaderRow+1; i < sv.rowLimit(g.Rows()); i+=1 {
 		// if no sample defined, then we're done
-		if zstr, ok = row[sv.ZSampleColumn].(string); !ok || len(zstr)==0 {
+		if g.IsEmpty(i, sv.ZSampleColumn) {
 			break
 		}
 		nzsamples += 1
@@ -179,15 +446,15 @@ func evalSheetVariant(sv *sheetVariant, data *sheets.ValueRange) bool {
 		hasMaxDistance := false
 
 		// we have a ZSample defined, check sysname
-		if sysstr, ok = row[sv.SysNameColumn].(string); ok && len(sysstr)>=0 {
+		if _, ok := g.Raw(i, sv.SysNameColumn).(string); ok {
 			hasSysName = true
 		}
-		if syscount, err := strconv.Atoi(row[sv.SystemCountColumn].(string)); err == nil &&
+		if syscount, err := g.Int(i, sv.SystemCountColumn); err == nil &&
 			syscount >= 0 && syscount < 50 {
 			hasSysCount = true
 		}
-		if len(row) > sv.MaxDistanceColumn {
-			if maxdst, err := strconv.ParseFloat(row[sv.MaxDistanceColumn].(string), 32); err == nil && maxdst >= 0 && maxdst <= 20 {
+		if sv.MaxDistanceColumn >= 0 {
+			if maxdst, err := g.Float(i, sv.MaxDistanceColumn); err == nil && maxdst >= 0 && maxdst <= 20 {
 				hasMaxDistance = true
 			}
 		}
@@ -196,5 +463,5 @@ func evalSheetVariant(sv *sheetVariant, data *sheets.ValueRange) bool {
 			nsamples += 1
 		}
 	}
-	return float32(nzsamples) * sv.MinSampleRatio < float32(nsamples)
+	return nzsamples, nsamples
 }
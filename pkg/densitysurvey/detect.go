@@ -0,0 +1,205 @@
+package densitysurvey
+
+import (
+	"fmt"
+	"sort"
+	"errors"
+	"slices"
+	"strings"
+	"unicode"
+
+)
+
+const (
+	// the width of the range read when detection is enabled
+	detectColumns = 26
+	// the name of the detected variants, followed by @ and the header row
+	detectedVariant = "detected"
+)
+
+var (
+	// header names per column, matched after normalization
+	headerSynonyms = map[string][]string{
+		"sysname": {"system", "system name", "sys name", "star system", "name"},
+		"zsample": {"z sample", "zsample", "z target", "target z", "sample z", "height",
+			"target height", "sample height", "z level", "elevation", "sample"},
+		"systemcount": {"system count", "sys count", "systems count", "count", "n",
+			"star count", "number of systems", "systems"},
+		"maxdistance": {"max distance", "max dist", "maximum distance", "distance",
+			"dist", "radius", "r", "max range", "range"},
+		"x": {"x", "x coord", "x coordinate"},
+		"z": {"z", "z coord", "z coordinate"},
+		"y": {"y", "y coord", "y coordinate"},
+	}
+)
+
+// a candidate match of a header cell to a column
+type headerMatch struct {
+	column string
+	index int
+	score float32
+}
+
+// detectVariant scans the top rows for a header row and builds an ad-hoc
+// variant from the columns recognized by their names. Returns nil when
+// no row has at least the system name, z-sample and count columns, or the
+// rows below don't look like samples, with the reason.
+func (p *Parser) detectVariant(g *cellGrid) (*sheetVariant, error) {
+	if !p.detect.Enabled {
+		return nil, errDetectDisabled
+	}
+
+	var rejected error = nil
+
+	for r := 0; r < g.Rows() && r < p.detect.ScanRows; r += 1 {
+		columns := p.matchHeaderRow(g.Row(r))
+
+		required := []string{"sysname", "zsample", "systemcount"}
+		found := true
+		for _, col := range required {
+			if _, ok := columns[col]; !ok {
+				found = false
+				break
+			}
+		}
+		if !found {
+			continue
+		}
+
+		sv := &sheetVariant{
+			Name: fmt.Sprintf("%s@%d", detectedVariant, r+1),
+			HeaderRow: r,
+			SysNameColumn: columns["sysname"],
+			ZSampleColumn: columns["zsample"],
+			SampleIndicatorColumn: columns["zsample"],
+			SystemCountColumn: columns["systemcount"],
+			MaxDistanceColumn: -1,
+			XColumn: -1,
+			ZColumn: -1,
+			YColumn: -1,
+			MinSampleRatio: p.detect.MinSampleRatio,
+			DefaultMaxDistance: 20,
+		}
+		optional := map[string]*int{
+			"maxdistance": &sv.MaxDistanceColumn,
+			"x": &sv.XColumn,
+			"z": &sv.ZColumn,
+			"y": &sv.YColumn,
+		}
+		for col, dst := range optional {
+			if idx, ok := columns[col]; ok {
+				*dst = idx
+			}
+		}
+
+		// detected variants are not bound to a project
+		if err := checkSheetVariant(sv, g, ""); err != nil {
+			rejected = errors.Join(rejected, err)
+			continue
+		}
+		return sv, nil
+	}
+
+	if rejected == nil {
+		rejected = fmt.Errorf("%s/%s: no header row with the system name, z-sample and system count in the top %d rows",
+			g.spreadsheetID, g.tab, p.detect.ScanRows)
+	}
+	return nil, rejected
+}
+
+// matchHeaderRow maps the cells of a row to columns, best matches first,
+// each cell and each column used once
+func (p *Parser) matchHeaderRow(row []interface{}) map[string]int {
+	matches := []headerMatch{}
+
+	for i, cell := range row {
+		str, ok := cell.(string)
+		if !ok {
+			continue
+		}
+		header := normalizeHeader(str)
+		if header == "" {
+			continue
+		}
+		for column, synonyms := range headerSynonyms {
+			synonyms = append(slices.Clone(synonyms), p.detect.Synonyms[column]...)
+			if score := matchHeader(header, synonyms); score > 0 {
+				matches = append(matches, headerMatch{column, i, score})
+			}
+		}
+	}
+
+	sort.SliceStable(matches, func(a, b int) bool {
+		if matches[a].score != matches[b].score {
+			return matches[a].score > matches[b].score
+		}
+		return matches[a].index < matches[b].index
+	})
+
+	ret := map[string]int{}
+	used := map[int]bool{}
+	for _, m := range matches {
+		if _, ok := ret[m.column]; ok || used[m.index] {
+			continue
+		}
+		ret[m.column] = m.index
+		used[m.index] = true
+	}
+	return ret
+}
+
+// matchHeader scores a normalized header against the synonyms of a column:
+// 1 for an exact match, less for a close typo or the synonym being part of
+// the header, 0 for no match
+func matchHeader(header string, synonyms []string) float32 {
+	var best float32 = 0
+
+	for _, syn := range synonyms {
+		syn = normalizeHeader(syn)
+		switch {
+		case header == syn:
+			return 1
+		// short names like x or n would match anything
+		case len(syn) < 4:
+			continue
+		case levenshtein(header, syn) <= len(syn)/5:
+			best = max(best, 0.8)
+		case strings.Contains(" "+header+" ", " "+syn+" "):
+			best = max(best, 0.6)
+		}
+	}
+
+	return best
+}
+
+// normalizeHeader lowercases and keeps only letters and digits, separated by
+// single spaces
+func normalizeHeader(s string) string {
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(fields, " ")
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i += 1 {
+		cur[0] = i
+		for j := 1; j <= len(rb); j += 1 {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(rb)]
+}
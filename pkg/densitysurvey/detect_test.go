@@ -0,0 +1,179 @@
+package densitysurvey
+
+import (
+	"testing"
+
+	"google.golang.org/api/sheets/v4"
+
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/config"
+)
+
+func testGrid(values [][]interface{}) *cellGrid {
+	return newCellGrid("sheet", "tab", &sheets.ValueRange{Values: values})
+}
+
+func TestNormalizeHeader(t *testing.T) {
+	tests := []struct {
+		in string
+		want string
+	}{
+		{"System Name", "system name"},
+		{"  Z-Sample:  ", "z sample"},
+		{"Max. Distance (ly)", "max distance ly"},
+		{"sys_count#2", "sys count 2"},
+		{"---", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeHeader(tt.in); got != tt.want {
+			t.Errorf("normalizeHeader(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMatchHeader(t *testing.T) {
+	tests := []struct {
+		header string
+		column string
+		want float32
+	}{
+		{"system name", "sysname", 1},
+		{"z sample", "zsample", 1},
+		{"n", "systemcount", 1},
+		// typo
+		{"systme count", "systemcount", 0.8},
+		// part of the header
+		{"the max distance in ly", "maxdistance", 0.6},
+		// short synonyms only match exactly
+		{"next", "systemcount", 0},
+		{"notes", "sysname", 0},
+	}
+	for _, tt := range tests {
+		if got := matchHeader(tt.header, headerSynonyms[tt.column]); got != tt.want {
+			t.Errorf("matchHeader(%q, %s) = %v, want %v", tt.header, tt.column, got, tt.want)
+		}
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"kitten", "sitting", 3},
+		{"system", "sytsem", 2},
+	}
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestMatchHeaderRow(t *testing.T) {
+	p := &Parser{
+		detect: config.DetectConfig{
+			Synonyms: map[string][]string{"zsample": {"target y"}},
+		},
+	}
+
+	got := p.matchHeaderRow([]interface{}{"#", "Star System", "Target Y", "Count", "Max Dist", 12.5, "X", "Y", "Z"})
+	want := map[string]int{
+		"sysname": 1,
+		"zsample": 2,
+		"systemcount": 3,
+		"maxdistance": 4,
+		"x": 6,
+		"y": 7,
+		"z": 8,
+	}
+	if len(got) != len(want) {
+		t.Errorf("matched %v, want %v", got, want)
+	}
+	for col, idx := range want {
+		if got[col] != idx {
+			t.Errorf("%s: column %d, want %d", col, got[col], idx)
+		}
+	}
+}
+
+func TestDetectVariant(t *testing.T) {
+	detect := config.DetectConfig{
+		Enabled: true,
+		ScanRows: 5,
+		MinSampleRatio: 0.5,
+	}
+
+	tests := []struct {
+		name string
+		values [][]interface{}
+		// the expected header row and columns, -1 for no match
+		row int
+		sysname, zsample, count, maxdistance int
+	}{
+		{
+			name: "header below the title",
+			values: [][]interface{}{
+				{"CMDR Foo - Some Project"},
+				{},
+				{"Z Sample", "System", "System Count", "Max Distance"},
+				{0.0, "Sys A", 10.0, 20.0},
+				{50.0, "Sys B", 8.0, 20.0},
+			},
+			row: 2, sysname: 1, zsample: 0, count: 2, maxdistance: 3,
+		},
+		{
+			name: "without max distance",
+			values: [][]interface{}{
+				{"System Name", "Height", "Systems"},
+				{"Sys A", 0.0, 10.0},
+			},
+			row: 0, sysname: 0, zsample: 1, count: 2, maxdistance: -1,
+		},
+		{
+			name: "no system count column",
+			values: [][]interface{}{
+				{"System", "Z Sample"},
+				{"Sys A", 0.0},
+			},
+			row: -1,
+		},
+		{
+			name: "headers without samples below",
+			values: [][]interface{}{
+				{"System", "Z Sample", "Count"},
+				{5.0, 0.0, "many"},
+				{6.0, 50.0, "few"},
+			},
+			row: -1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Parser{detect: detect}
+			sv, err := p.detectVariant(testGrid(tt.values))
+			if tt.row < 0 {
+				if sv != nil || err == nil {
+					t.Errorf("detected %+v, want none", sv)
+				}
+				return
+			}
+			if sv == nil {
+				t.Fatalf("nothing detected: %v", err)
+			}
+			if sv.HeaderRow != tt.row || sv.SysNameColumn != tt.sysname || sv.ZSampleColumn != tt.zsample ||
+				sv.SystemCountColumn != tt.count || sv.MaxDistanceColumn != tt.maxdistance {
+				t.Errorf("detected row %d columns %d/%d/%d/%d, want row %d columns %d/%d/%d/%d",
+					sv.HeaderRow, sv.SysNameColumn, sv.ZSampleColumn, sv.SystemCountColumn, sv.MaxDistanceColumn,
+					tt.row, tt.sysname, tt.zsample, tt.count, tt.maxdistance)
+			}
+		})
+	}
+
+	p := &Parser{detect: config.DetectConfig{}}
+	if _, err := p.detectVariant(testGrid(tests[0].values)); err != errDetectDisabled {
+		t.Errorf("disabled detection: %v, want %v", err, errDetectDisabled)
+	}
+}
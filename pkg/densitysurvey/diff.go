@@ -0,0 +1,196 @@
+package densitysurvey
+
+import (
+	"io"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const (
+	DiffAdded = "added"
+	DiffRemoved = "removed"
+	DiffChanged = "changed"
+	DiffUnchanged = "unchanged"
+	// the tab couldn't be parsed
+	DiffError = "error"
+)
+
+// PointValues are the compared values of a survey point
+type PointValues struct {
+	SystemName string `json:"system"`
+	Count int `json:"count"`
+	MaxDistance float32 `json:"maxdistance"`
+}
+
+// PointDiff is a difference of a survey point, the points are matched by
+// their z-sample
+type PointDiff struct {
+	Change string `json:"change"`
+	ZSample float32 `json:"zsample"`
+	// nil for the added points
+	Stored *PointValues `json:"stored,omitempty"`
+	// nil for the removed points
+	Current *PointValues `json:"current,omitempty"`
+}
+
+// TabDiff is the difference of a tab's stored and current survey
+type TabDiff struct {
+	Tab string `json:"tab"`
+	// added: new tab, removed: the tab is gone or not a survey anymore
+	Change string `json:"change"`
+	Error string `json:"error,omitempty"`
+	Added int `json:"added"`
+	Removed int `json:"removed"`
+	Changed int `json:"changed"`
+	Points []PointDiff `json:"points"`
+}
+
+// SpreadsheetDiff is the difference of the stored surveys and the current
+// contents of a spreadsheet
+type SpreadsheetDiff struct {
+	SpreadsheetID string `json:"spreadsheetid"`
+	Tabs []TabDiff `json:"tabs"`
+}
+
+// DiffSpreadsheet compares the stored points, keyed by the tab, with the
+// spreadsheet's current parse results
+func DiffSpreadsheet(spreadsheetID string, stored map[string][]SurveyPoint, results []TabResult) *SpreadsheetDiff {
+	ret := &SpreadsheetDiff{
+		SpreadsheetID: spreadsheetID,
+		Tabs: []TabDiff{},
+	}
+
+	seen := map[string]bool{}
+	for _, res := range results {
+		seen[res.Tab] = true
+		var uverr *UnknownVariantError
+		switch {
+		case res.Err == nil:
+			ret.Tabs = append(ret.Tabs, diffPoints(res.Tab, stored[res.Tab], res.Survey.SurveyPoints))
+		case errors.As(res.Err, &uverr):
+			// not a survey, unless it was one
+			if points, ok := stored[res.Tab]; ok {
+				ret.Tabs = append(ret.Tabs, diffPoints(res.Tab, points, nil))
+			}
+		default:
+			ret.Tabs = append(ret.Tabs, TabDiff{
+				Tab: res.Tab,
+				Change: DiffError,
+				Error: res.Err.Error(),
+				Points: []PointDiff{},
+			})
+		}
+	}
+
+	tabs := []string{}
+	for tab := range stored {
+		if !seen[tab] {
+			tabs = append(tabs, tab)
+		}
+	}
+	sort.Strings(tabs)
+	for _, tab := range tabs {
+		ret.Tabs = append(ret.Tabs, diffPoints(tab, stored[tab], nil))
+	}
+
+	return ret
+}
+
+// diffPoints compares the points, nil stored means a new tab, nil current
+// a removed one
+func diffPoints(tab string, stored, current []SurveyPoint) TabDiff {
+	td := TabDiff{
+		Tab: tab,
+		Change: DiffUnchanged,
+		Points: []PointDiff{},
+	}
+	switch {
+	case stored == nil:
+		td.Change = DiffAdded
+	case current == nil:
+		td.Change = DiffRemoved
+	}
+
+	old := map[float32]*SurveyPoint{}
+	for i := range stored {
+		old[stored[i].ZSample] = &stored[i]
+	}
+	matched := map[float32]bool{}
+	for i := range current {
+		cur := &current[i]
+		prev, ok := old[cur.ZSample]
+		if !ok {
+			td.Added += 1
+			td.Points = append(td.Points, PointDiff{
+				Change: DiffAdded,
+				ZSample: cur.ZSample,
+				Current: pointValues(cur),
+			})
+			continue
+		}
+		matched[cur.ZSample] = true
+		if *pointValues(prev) != *pointValues(cur) {
+			td.Changed += 1
+			td.Points = append(td.Points, PointDiff{
+				Change: DiffChanged,
+				ZSample: cur.ZSample,
+				Stored: pointValues(prev),
+				Current: pointValues(cur),
+			})
+		}
+	}
+	for i := range stored {
+		if !matched[stored[i].ZSample] {
+			td.Removed += 1
+			td.Points = append(td.Points, PointDiff{
+				Change: DiffRemoved,
+				ZSample: stored[i].ZSample,
+				Stored: pointValues(&stored[i]),
+			})
+		}
+	}
+	sort.SliceStable(td.Points, func(a, b int) bool {
+		return td.Points[a].ZSample < td.Points[b].ZSample
+	})
+
+	if td.Change == DiffUnchanged && td.Added+td.Removed+td.Changed > 0 {
+		td.Change = DiffChanged
+	}
+	return td
+}
+
+func pointValues(dp *SurveyPoint) *PointValues {
+	return &PointValues{
+		SystemName: dp.SystemName,
+		Count: dp.Count,
+		MaxDistance: dp.MaxDistance,
+	}
+}
+
+// Write prints the human readable diff
+func (d *SpreadsheetDiff) Write(w io.Writer) {
+	fmt.Fprintf(w, "=== %s ===\n", d.SpreadsheetID)
+	for _, td := range d.Tabs {
+		if td.Change == DiffError {
+			fmt.Fprintf(w, "%s: %s: %s\n", td.Tab, td.Change, td.Error)
+			continue
+		}
+		fmt.Fprintf(w, "%s: %s added:%d removed:%d changed:%d\n", td.Tab, td.Change,
+			td.Added, td.Removed, td.Changed)
+		for _, pd := range td.Points {
+			switch pd.Change {
+			case DiffAdded:
+				fmt.Fprintf(w, "  + z=%g %s\n", pd.ZSample, pd.Current)
+			case DiffRemoved:
+				fmt.Fprintf(w, "  - z=%g %s\n", pd.ZSample, pd.Stored)
+			case DiffChanged:
+				fmt.Fprintf(w, "  ~ z=%g %s -> %s\n", pd.ZSample, pd.Stored, pd.Current)
+			}
+		}
+	}
+}
+
+func (pv *PointValues) String() string {
+	return fmt.Sprintf("%s count:%d maxdistance:%g", pv.SystemName, pv.Count, pv.MaxDistance)
+}
@@ -0,0 +1,158 @@
+package densitysurvey
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"bytes"
+)
+
+func point(z float32, name string, count int, maxdist float32) SurveyPoint {
+	return SurveyPoint{ZSample: z, SystemName: name, Count: count, MaxDistance: maxdist}
+}
+
+func TestDiffPoints(t *testing.T) {
+	stored := []SurveyPoint{
+		point(0, "Sys A", 10, 20),
+		point(50, "Sys B", 8, 20),
+		point(100, "Sys C", 6, 20),
+	}
+
+	tests := []struct {
+		name string
+		stored, current []SurveyPoint
+		change string
+		added, removed, changed int
+		// the changes of the points, by z-sample
+		points []string
+	}{
+		{
+			name: "unchanged",
+			stored: stored,
+			current: []SurveyPoint{point(100, "Sys C", 6, 20), point(0, "Sys A", 10, 20), point(50, "Sys B", 8, 20)},
+			change: DiffUnchanged,
+			points: []string{},
+		},
+		{
+			name: "changed",
+			stored: stored,
+			current: []SurveyPoint{
+				point(0, "Sys A", 11, 20),
+				point(50, "Sys B", 8, 20),
+				point(75, "Sys D", 7, 20),
+				point(100, "Sys E", 6, 20),
+			},
+			change: DiffChanged,
+			added: 1, changed: 2,
+			points: []string{DiffChanged, DiffAdded, DiffChanged},
+		},
+		{
+			name: "points removed",
+			stored: stored,
+			current: []SurveyPoint{point(50, "Sys B", 8, 21)},
+			change: DiffChanged,
+			removed: 2, changed: 1,
+			points: []string{DiffRemoved, DiffChanged, DiffRemoved},
+		},
+		{
+			name: "new tab",
+			current: stored,
+			change: DiffAdded,
+			added: 3,
+			points: []string{DiffAdded, DiffAdded, DiffAdded},
+		},
+		{
+			name: "removed tab",
+			stored: stored,
+			change: DiffRemoved,
+			removed: 3,
+			points: []string{DiffRemoved, DiffRemoved, DiffRemoved},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			td := diffPoints("tab", tt.stored, tt.current)
+			if td.Tab != "tab" || td.Change != tt.change || td.Added != tt.added || td.Removed != tt.removed ||
+				td.Changed != tt.changed {
+				t.Errorf("%s added:%d removed:%d changed:%d, want %s added:%d removed:%d changed:%d",
+					td.Change, td.Added, td.Removed, td.Changed, tt.change, tt.added, tt.removed, tt.changed)
+			}
+			if len(td.Points) != len(tt.points) {
+				t.Fatalf("points %+v, want %v", td.Points, tt.points)
+			}
+			for i, pd := range td.Points {
+				if pd.Change != tt.points[i] || (i > 0 && pd.ZSample < td.Points[i-1].ZSample) {
+					t.Errorf("point %d: %+v, want %s in z-sample order", i, pd, tt.points[i])
+				}
+				if (pd.Stored == nil) != (pd.Change == DiffAdded) || (pd.Current == nil) != (pd.Change == DiffRemoved) {
+					t.Errorf("point %d: %s with stored %v, current %v", i, pd.Change, pd.Stored, pd.Current)
+				}
+			}
+		})
+	}
+}
+
+func TestDiffSpreadsheet(t *testing.T) {
+	stored := map[string][]SurveyPoint{
+		"same": {point(0, "Sys A", 10, 20)},
+		"edited": {point(0, "Sys A", 10, 20)},
+		"not a survey anymore": {point(0, "Sys A", 10, 20)},
+		"broken": {point(0, "Sys A", 10, 20)},
+		"deleted b": {point(0, "Sys A", 10, 20)},
+		"deleted a": {point(0, "Sys A", 10, 20)},
+	}
+	survey := func(points ...SurveyPoint) *Survey {
+		return &Survey{SurveyPoints: points}
+	}
+	results := []TabResult{
+		{Tab: "same", Survey: survey(point(0, "Sys A", 10, 20))},
+		{Tab: "edited", Survey: survey(point(0, "Sys A", 12, 20))},
+		{Tab: "new", Survey: survey(point(0, "Sys A", 10, 20), point(50, "Sys B", 8, 20))},
+		{Tab: "not a survey anymore", Err: &UnknownVariantError{Tab: "not a survey anymore"}},
+		{Tab: "notes", Err: &UnknownVariantError{Tab: "notes"}},
+		{Tab: "broken", Err: errors.New("too few samples")},
+	}
+
+	d := DiffSpreadsheet("sheet", stored, results)
+	want := []struct {
+		tab string
+		change string
+	}{
+		{"same", DiffUnchanged},
+		{"edited", DiffChanged},
+		{"new", DiffAdded},
+		{"not a survey anymore", DiffRemoved},
+		{"broken", DiffError},
+		// the tabs gone from the spreadsheet, sorted
+		{"deleted a", DiffRemoved},
+		{"deleted b", DiffRemoved},
+	}
+
+	if d.SpreadsheetID != "sheet" || len(d.Tabs) != len(want) {
+		t.Fatalf("diff %+v", d)
+	}
+	for i, w := range want {
+		if d.Tabs[i].Tab != w.tab || d.Tabs[i].Change != w.change {
+			t.Errorf("tab %d: %s %s, want %s %s", i, d.Tabs[i].Tab, d.Tabs[i].Change, w.tab, w.change)
+		}
+	}
+	if d.Tabs[4].Error != "too few samples" || d.Tabs[4].Points == nil {
+		t.Errorf("error tab %+v", d.Tabs[4])
+	}
+
+	var buf bytes.Buffer
+	d.Write(&buf)
+	for _, line := range []string{
+		"=== sheet ===",
+		"edited: changed added:0 removed:0 changed:1",
+		"  ~ z=0 Sys A count:10 maxdistance:20 -> Sys A count:12 maxdistance:20",
+		"  + z=50 Sys B count:8 maxdistance:20",
+		"  - z=0 Sys A count:10 maxdistance:20",
+		"broken: error: too few samples",
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("output has no %q:\n%s", line, buf.String())
+		}
+	}
+}
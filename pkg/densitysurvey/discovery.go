@@ -0,0 +1,131 @@
+package densitysurvey
+
+import (
+	"fmt"
+	"errors"
+	"regexp"
+	"context"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/config"
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/google"
+)
+
+// FolderLister lists the content of a Drive folder, see google.GDriveService
+type FolderLister interface {
+	ListFolder(ctx context.Context, folderid string) ([]*drive.File, error)
+}
+
+// FolderDiscovery finds the survey spreadsheets in Drive folders, as an
+// alternative or addition to the EntrySheet
+type FolderDiscovery struct {
+	lister FolderLister
+	folders []string
+	recursive bool
+	name *regexp.Regexp
+	owners []string
+}
+
+func NewFolderDiscovery(lister FolderLister, cfg *config.DiscoveryConfig) (*FolderDiscovery, error) {
+	fd := &FolderDiscovery{
+		lister: lister,
+		recursive: cfg.Recursive,
+	}
+
+	for _, folder := range cfg.Folders {
+		id, err := extractSpreadsheetID(strings.TrimSpace(folder))
+		if err != nil {
+			return nil, errors.Join(err, fmt.Errorf("Invalid discovery folder %q", folder))
+		}
+		fd.folders = append(fd.folders, id)
+	}
+	if cfg.Name != "" {
+		var err error
+		if fd.name, err = regexp.Compile(cfg.Name); err != nil {
+			return nil, errors.Join(err, fmt.Errorf("Invalid discovery.name"))
+		}
+	}
+	for _, owner := range cfg.Owners {
+		fd.owners = append(fd.owners, strings.ToLower(strings.TrimSpace(owner)))
+	}
+
+	return fd, nil
+}
+
+// GetSheetIDs lists the spreadsheets of the folders matching the filters.
+// A folder which couldn't be listed doesn't stop the rest.
+func (fd *FolderDiscovery) GetSheetIDs(ctx context.Context) ([]string, error) {
+	var reterr error = nil
+	ret := []string{}
+
+	visited := map[string]bool{}
+	found := map[string]bool{}
+	pending := append([]string{}, fd.folders...)
+
+	for len(pending) > 0 {
+		folder := pending[0]
+		pending = pending[1:]
+		if visited[folder] {
+			continue
+		}
+		visited[folder] = true
+
+		files, err := fd.lister.ListFolder(ctx, folder)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ret, ctx.Err()
+			}
+			reterr = errors.Join(reterr, err)
+			continue
+		}
+		for _, f := range files {
+			switch f.MimeType {
+			case google.MimeFolder:
+				if fd.recursive {
+					pending = append(pending, f.Id)
+				}
+			case google.MimeSpreadsheet:
+				if !found[f.Id] && fd.match(f) {
+					found[f.Id] = true
+					ret = append(ret, f.Id)
+				}
+			}
+		}
+	}
+
+	return ret, reterr
+}
+
+// GetEntries is GetSheetIDs as entries, without any overrides
+func (fd *FolderDiscovery) GetEntries(ctx context.Context) ([]Entry, error) {
+	ret := []Entry{}
+
+	ids, err := fd.GetSheetIDs(ctx)
+	for _, id := range ids {
+		ret = append(ret, Entry{
+			SheetID: id,
+			Row: -1,
+		})
+	}
+
+	return ret, err
+}
+
+func (fd *FolderDiscovery) match(f *drive.File) bool {
+	if fd.name != nil && !fd.name.MatchString(f.Name) {
+		return false
+	}
+	if len(fd.owners) == 0 {
+		return true
+	}
+	for _, owner := range f.Owners {
+		for _, want := range fd.owners {
+			if strings.ToLower(owner.EmailAddress) == want || strings.ToLower(owner.DisplayName) == want {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -3,53 +3,155 @@ package densitysurvey
 import (
 	"fmt"
 	"errors"
+	"context"
 	"strings"
 	"net/url"
 	"regexp"
+
+	"google.golang.org/api/sheets/v4"
+
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/config"
 	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/google"
 )
 
+// Entry is a spreadsheet to ingest, with the coordinators' settings
+type Entry struct {
+	SheetID string
+	// 0-based row of the entry sheet, -1 when it's not from there
+	Row int
+	// overrides, empty if not set
+	CMDR string
+	Campaign string
+	Skip bool
+	Notes string
+}
+
+// EntryStatus is the outcome of an entry's ingest, written back to the
+// entry sheet
+type EntryStatus struct {
+	Row int
+	LastIngested string
+	Result string
+	Points int
+	Errors string
+}
+
 type EntrySheet struct {
 	spreadsheet *google.GSpreadsheet
+	cfg *config.EntrySheetConfig
+	// the columns' indexes, -1 if not used
+	id, campaign, cmdr, skip, notes int
 }
 
-func NewEntrySheet(sheetid string, ss *google.GSpreadsheetsService) (*EntrySheet, error) {
-	s, err := ss.Sheet(sheetid)
+func NewEntrySheet(ctx context.Context, sheetid string, ss SheetSource, cfg *config.EntrySheetConfig) (*EntrySheet, error) {
+	var err error
+
+	es := &EntrySheet{
+		cfg: cfg,
+	}
+	cols := []struct {
+		letters string
+		dst *int
+	}{
+		{cfg.Columns.ID, &es.id},
+		{cfg.Columns.Campaign, &es.campaign},
+		{cfg.Columns.CMDR, &es.cmdr},
+		{cfg.Columns.Skip, &es.skip},
+		{cfg.Columns.Notes, &es.notes},
+	}
+	for _, c := range cols {
+		*c.dst = -1
+		if c.letters == "" {
+			continue
+		}
+		if *c.dst, err = columnIndex(c.letters); err != nil {
+			return nil, errors.Join(err, fmt.Errorf("Invalid entry sheet column %q", c.letters))
+		}
+	}
+	if es.id < 0 {
+		return nil, fmt.Errorf("entrysheet.columns.id is required")
+	}
+
+	s, err := ss.Sheet(ctx, sheetid)
 	if err != nil {
-		fmt.Printf("NewEntrySheet err: %v\n", err)
 		return nil, errors.Join(err, fmt.Errorf("Unable to load sheet %s", sheetid))
 	}
+	es.spreadsheet = s
 
-	return &EntrySheet{
-		spreadsheet: s,
-	}, nil
+	return es, nil
 }
 
-func (es *EntrySheet) GetSheetIDs() ([]string, error) {
+// GetSheetIDs returns the IDs of the entries not skipped
+func (es *EntrySheet) GetSheetIDs(ctx context.Context) ([]string, error) {
+	ret := []string{}
+
+	entries, err := es.GetEntries(ctx)
+	for _, e := range entries {
+		if !e.Skip {
+			ret = append(ret, e.SheetID)
+		}
+	}
+
+	return ret, err
+}
+
+// GetEntries reads the entries of the first sheet. The IDs are taken from
+// all the links of the cells (hyperlinks, rich text, HYPERLINK formulas),
+// or from their text, a cell with multiple links gives multiple entries.
+// Rows without a valid spreadsheet link or ID are left out, their errors
+// are returned joined.
+func (es *EntrySheet) GetEntries(ctx context.Context) ([]Entry, error) {
 	var reterr error = nil
-	sheetids := []string{}
+	entries := []Entry{}
 
 	sheetname := es.spreadsheet.GetSheets()[0].Properties.Title
+	lastcol := max(es.id, es.campaign, es.cmdr, es.skip, es.notes)
 
 	step := 1024
-	pos := 1
+	pos := max(es.cfg.FirstRow, 1)
 	cont := true
 
 	for {
-		startcell := fmt.Sprintf("A%d", pos)
-		endcell := fmt.Sprintf("A%d", pos+step)
-		data, err := es.spreadsheet.ReadRange(sheetname, startcell, endcell)
+		rangestr := fmt.Sprintf("%s!A%d:%s", google.QuoteSheet(sheetname), pos, cellRef(pos-1+step-1, lastcol))
+		ss, err := es.spreadsheet.GridDataRanges(ctx, []string{rangestr}, linkFields)
 		if err != nil {
-			return []string{}, errors.Join(err, fmt.Errorf("Error while reading %s/%s!%s:%s",
-			es.spreadsheet.ID, sheetname, startcell, endcell))
+			return []Entry{}, errors.Join(err, fmt.Errorf("Error while reading %s/%s",
+			es.spreadsheet.ID, rangestr))
+		}
+		rows := []*sheets.RowData{}
+		if len(ss.Sheets) > 0 && len(ss.Sheets[0].Data) > 0 {
+			rows = ss.Sheets[0].Data[0].RowData
 		}
-		cont = len(data.Values)==step
+		cont = len(rows)==step
 
-		for _, row := range data.Values {
-			if id, err := extractSpreadsheetID(row[0].(string)); err != nil {
-				reterr = errors.Join(reterr, err)
-			} else {
-				sheetids = append(sheetids, id)
+		g := &cellGrid{
+			spreadsheetID: es.spreadsheet.ID,
+			tab: sheetname,
+			values: gridValues(rows),
+		}
+		for i := 0; i < g.Rows(); i += 1 {
+			links := cellLinks(gridCell(rows, i, es.id))
+			if len(links) == 0 && g.IsEmpty(i, es.id) {
+				continue
+			}
+			// the grid starts at pos
+			row := pos-1+i
+			ids, err := entryIDs(strings.TrimSpace(g.String(i, es.id)), links)
+			if len(ids) == 0 {
+				cerr := g.cellError(i, es.id, err)
+				cerr.Cell = cellRef(row, es.id)
+				reterr = errors.Join(reterr, cerr)
+				continue
+			}
+			for _, id := range ids {
+				entries = append(entries, Entry{
+					SheetID: id,
+					Row: row,
+					CMDR: es.optional(g, i, es.cmdr),
+					Campaign: es.optional(g, i, es.campaign),
+					Skip: es.skip >= 0 && isChecked(g.Raw(i, es.skip)),
+					Notes: es.optional(g, i, es.notes),
+				})
 			}
 		}
 		pos += step
@@ -58,7 +160,101 @@ func (es *EntrySheet) GetSheetIDs() ([]string, error) {
 		}
 	}
 
-	return sheetids, reterr
+	return entries, reterr
+}
+
+// entryIDs are the spreadsheet IDs of the links, or the text when there are
+// no links. Links to something else are ignored, the error is only
+// relevant when no ID was found.
+func entryIDs(text string, links []string) ([]string, error) {
+	var reterr error = nil
+	ret := []string{}
+
+	if len(links) == 0 {
+		links = []string{text}
+	}
+	seen := map[string]bool{}
+	for _, link := range links {
+		id, err := extractSpreadsheetID(link)
+		if err != nil {
+			reterr = errors.Join(reterr, err)
+			continue
+		}
+		if !seen[id] {
+			seen[id] = true
+			ret = append(ret, id)
+		}
+	}
+
+	return ret, reterr
+}
+
+func (es *EntrySheet) optional(g *cellGrid, row, col int) string {
+	if col < 0 {
+		return ""
+	}
+	return strings.TrimSpace(g.String(row, col))
+}
+
+// isChecked tells whether a cell is a ticked checkbox, or says yes
+func isChecked(v interface{}) bool {
+	switch cv := v.(type) {
+	case bool:
+		return cv
+	case float64:
+		return cv != 0
+	case string:
+		switch strings.ToLower(strings.TrimSpace(cv)) {
+		case "x", "y", "yes", "true", "1", "skip":
+			return true
+		}
+	}
+	return false
+}
+
+// WriteStatus writes the outcome of the ingest into the configured status
+// columns of the entries' rows, in one batch
+func (es *EntrySheet) WriteStatus(ctx context.Context, statuses []EntryStatus) error {
+	sheetname := es.spreadsheet.GetSheets()[0].Properties.Title
+	cols := []struct {
+		letters string
+		value func(*EntryStatus) interface{}
+	}{
+		{es.cfg.Status.LastIngested, func(st *EntryStatus) interface{} { return st.LastIngested }},
+		{es.cfg.Status.Result, func(st *EntryStatus) interface{} { return st.Result }},
+		{es.cfg.Status.Points, func(st *EntryStatus) interface{} { return st.Points }},
+		{es.cfg.Status.Errors, func(st *EntryStatus) interface{} { return st.Errors }},
+	}
+
+	data := []*sheets.ValueRange{}
+	for _, c := range cols {
+		if c.letters == "" {
+			continue
+		}
+		col, err := columnIndex(c.letters)
+		if err != nil {
+			return errors.Join(err, fmt.Errorf("Invalid entry sheet status column %q", c.letters))
+		}
+		for i := range statuses {
+			if statuses[i].Row < 0 {
+				continue
+			}
+			data = append(data, &sheets.ValueRange{
+				Range: fmt.Sprintf("%s!%s", google.QuoteSheet(sheetname), cellRef(statuses[i].Row, col)),
+				Values: [][]interface{}{{c.value(&statuses[i])}},
+			})
+		}
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	return es.spreadsheet.BatchWriteRanges(ctx, data)
+}
+
+// SpreadsheetID is the ID of a spreadsheet from its link, or the ID itself
+func SpreadsheetID(input string) (string, error) {
+	return extractSpreadsheetID(strings.TrimSpace(input))
 }
 
 // extractSpreadsheetID takes a string input, attempts to extract a Google Spreadsheet ID,
@@ -0,0 +1,94 @@
+package densitysurvey
+
+import (
+	"fmt"
+	"errors"
+	"strings"
+)
+
+// The errors of parsing the survey sheets. All of them carry the location
+// of the problem and wrap their cause, so errors.As works on them even
+// through the joined errors GetSurveys returns. CellError is in cells.go.
+
+// UnknownVariantError is a sheet none of the variants matched. Cause has
+// why each variant was rejected, joined.
+type UnknownVariantError struct {
+	SpreadsheetID string
+	Tab string
+	Cause error
+}
+
+func (e *UnknownVariantError) Error() string {
+	return fmt.Sprintf("Unable to identify sheet variant for %s/%s", e.SpreadsheetID, e.Tab)
+}
+
+func (e *UnknownVariantError) Unwrap() error {
+	return e.Cause
+}
+
+// HeaderMismatchError is a variant's header check failing on a sheet
+type HeaderMismatchError struct {
+	SpreadsheetID string
+	Tab string
+	Variant string
+	// A1 reference
+	Cell string
+	Expected string
+	Got string
+}
+
+func (e *HeaderMismatchError) Error() string {
+	return fmt.Sprintf("%s/%s!%s: variant %s expects '%s', got '%s'", e.SpreadsheetID, e.Tab,
+		e.Cell, e.Variant, e.Expected, e.Got)
+}
+
+// TooFewSamplesError is a variant's headers matching, but too few of the
+// sample rows being filled in properly
+type TooFewSamplesError struct {
+	SpreadsheetID string
+	Tab string
+	Variant string
+	// the rows with a z-sample, and the ones of them being valid samples
+	Rows int
+	Samples int
+	MinSampleRatio float32
+}
+
+func (e *TooFewSamplesError) Error() string {
+	ratio := float32(0)
+	if e.Rows > 0 {
+		ratio = float32(e.Samples) / float32(e.Rows)
+	}
+	return fmt.Sprintf("%s/%s: variant %s has %d valid samples of %d rows, ratio %.2f, needs above %.2f",
+		e.SpreadsheetID, e.Tab, e.Variant, e.Samples, e.Rows, ratio, e.MinSampleRatio)
+}
+
+// MetadataError is the survey's metadata (CMDR, project) missing or
+// malformed
+type MetadataError struct {
+	SpreadsheetID string
+	Tab string
+	// the missing ones, like cmdr or project
+	Fields []string
+	Cause error
+}
+
+func (e *MetadataError) Error() string {
+	msg := fmt.Sprintf("%s/%s: survey metadata missing: %s", e.SpreadsheetID, e.Tab,
+		strings.Join(e.Fields, ", "))
+	if e.Cause != nil {
+		msg += ": " + e.Cause.Error()
+	}
+	return msg
+}
+
+func (e *MetadataError) Unwrap() error {
+	return e.Cause
+}
+
+var (
+	errNoOverride = errors.New("neither the entry sheet nor the source sets them")
+	errUnanswered = errors.New("not answered in the form response")
+	errNoSamples = errors.New("no samples in the form response")
+	errDetectDisabled = errors.New("header-driven detection is disabled")
+)
@@ -0,0 +1,239 @@
+package densitysurvey
+
+import (
+	"fmt"
+	"math"
+	"time"
+	"errors"
+	"context"
+	"strings"
+
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/config"
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/google"
+)
+
+const (
+	// the variant name of the surveys from form responses
+	VariantForm = "form"
+)
+
+// FormResponses is a Google Forms response spreadsheet, each response row
+// holds a survey: the CMDR, the campaign and a block of answers per sample
+type FormResponses struct {
+	spreadsheet *google.GSpreadsheet
+	cfg *config.FormsConfig
+}
+
+// the columns of a sample's answers, -1 if not asked
+type formSample struct {
+	system, zsample, count, maxdistance int
+	// the z-sample when it's not asked
+	height float32
+}
+
+func NewFormResponses(ctx context.Context, sheetid string, ss SheetSource, cfg *config.FormsConfig) (*FormResponses, error) {
+	s, err := ss.Sheet(ctx, sheetid)
+	if err != nil {
+		return nil, errors.Join(err, fmt.Errorf("Unable to load sheet %s", sheetid))
+	}
+
+	return &FormResponses{
+		spreadsheet: s,
+		cfg: cfg,
+	}, nil
+}
+
+// ID is the ID of the spreadsheet
+func (fr *FormResponses) ID() string {
+	return fr.spreadsheet.ID
+}
+
+// Results parses the responses, each of them is a TabResult named by the
+// sheet and the row. The error is only set when the responses couldn't be
+// read at all, or the questions are not as configured.
+func (fr *FormResponses) Results(ctx context.Context) ([]TabResult, error) {
+	ret := []TabResult{}
+
+	tab := fr.cfg.Tab
+	if tab == "" {
+		tab = fr.spreadsheet.GetSheets()[0].Properties.Title
+	} else if fr.spreadsheet.SheetByTitle(tab) == nil {
+		return ret, fmt.Errorf("%s: no sheet %q", fr.spreadsheet.ID, tab)
+	}
+
+	vrs, err := fr.spreadsheet.BatchReadRanges(ctx, []string{google.QuoteSheet(tab)})
+	if err != nil {
+		return ret, err
+	}
+	g := newCellGrid(fr.spreadsheet.ID, tab, vrs[0])
+
+	headers := map[string]int{}
+	for i := range g.Row(0) {
+		if h := normalizeHeader(g.String(0, i)); h != "" {
+			if _, ok := headers[h]; !ok {
+				headers[h] = i
+			}
+		}
+	}
+	column := func(question string) int {
+		if idx, ok := headers[normalizeHeader(question)]; ok {
+			return idx
+		}
+		return -1
+	}
+
+	cmdrcol := column(fr.cfg.CMDR)
+	campaigncol := column(fr.cfg.Campaign)
+	if cmdrcol < 0 || campaigncol < 0 {
+		return ret, fmt.Errorf("%s/%s: the form has no %q or %q question", fr.spreadsheet.ID, tab,
+			fr.cfg.CMDR, fr.cfg.Campaign)
+	}
+	samples, err := fr.samples(column)
+	if err != nil {
+		return ret, fmt.Errorf("%s/%s: %w", fr.spreadsheet.ID, tab, err)
+	}
+
+	loc := fr.spreadsheet.Location()
+	for r := 1; r < g.Rows(); r += 1 {
+		if len(g.Row(r)) == 0 {
+			continue
+		}
+		m, err := fr.parseResponse(g, r, cmdrcol, campaigncol, column, samples, loc)
+		res := TabResult{
+			Tab: m.Name,
+			Err: err,
+		}
+		if err == nil {
+			res.Survey = &m
+		}
+		ret = append(ret, res)
+	}
+
+	return ret, nil
+}
+
+// samples are the columns of the samples' answers, as long as the form
+// asks for their systems
+func (fr *FormResponses) samples(column func(string) int) ([]formSample, error) {
+	ret := []formSample{}
+
+	for n := 1; ; n += 1 {
+		fs := formSample{
+			system: column(fmt.Sprintf(fr.cfg.System, n)),
+			zsample: column(fmt.Sprintf(fr.cfg.ZSample, n)),
+			count: column(fmt.Sprintf(fr.cfg.SystemCount, n)),
+			maxdistance: -1,
+		}
+		if fs.system < 0 {
+			break
+		}
+		if fr.cfg.MaxDistance != "" {
+			fs.maxdistance = column(fmt.Sprintf(fr.cfg.MaxDistance, n))
+		}
+		if fs.count < 0 {
+			return ret, fmt.Errorf("no %q question", fmt.Sprintf(fr.cfg.SystemCount, n))
+		}
+		if fs.zsample < 0 {
+			if n > len(fr.cfg.Heights) {
+				return ret, fmt.Errorf("no %q question and no height configured",
+					fmt.Sprintf(fr.cfg.ZSample, n))
+			}
+			fs.height = fr.cfg.Heights[n-1]
+		}
+		ret = append(ret, fs)
+	}
+	if len(ret) == 0 {
+		return ret, fmt.Errorf("no %q question", fmt.Sprintf(fr.cfg.System, 1))
+	}
+
+	return ret, nil
+}
+
+func (fr *FormResponses) parseResponse(g *cellGrid, r, cmdrcol, campaigncol int,
+	column func(string) int, samples []formSample, loc *time.Location) (Survey, error) {
+
+	m := Survey{
+		Name: fmt.Sprintf("%s!%d", g.tab, r+1),
+		SpreadsheetID: g.spreadsheetID,
+		Variant: VariantForm,
+		CMDR: strings.TrimSpace(g.String(r, cmdrcol)),
+		Project: strings.TrimSpace(g.String(r, campaigncol)),
+		SurveyPoints: make([]SurveyPoint, 0, len(samples)),
+	}
+	// the grid is shared by the responses
+	g.ambiguousCells()
+	if col := column(fr.cfg.Email); col >= 0 {
+		m.Respondent = strings.TrimSpace(g.String(r, col))
+	}
+	if col := column(fr.cfg.Timestamp); col >= 0 && !g.IsEmpty(r, col) {
+		if serial, err := g.Float(r, col); err == nil {
+			t := serialTime(serial, loc)
+			m.Submitted = &t
+		} else {
+			m.addCellIssue(r, col, "invalid timestamp", err)
+		}
+	}
+
+	if m.CMDR == "" || m.Project == "" {
+		merr := &MetadataError{
+			SpreadsheetID: g.spreadsheetID,
+			Tab: m.Name,
+			Cause: errUnanswered,
+		}
+		if m.CMDR == "" {
+			merr.Fields = append(merr.Fields, "cmdr")
+		}
+		if m.Project == "" {
+			merr.Fields = append(merr.Fields, "project")
+		}
+		return m, merr
+	}
+
+	for _, fs := range samples {
+		// unanswered samples
+		if g.IsEmpty(r, fs.system) {
+			continue
+		}
+
+		z := float64(fs.height)
+		if fs.zsample >= 0 {
+			var err error
+			if z, err = g.Float(r, fs.zsample); err != nil {
+				m.addCellIssue(r, fs.zsample, "sample skipped, invalid z-sample", err)
+				continue
+			}
+		}
+		c, err := g.Int(r, fs.count)
+		if err != nil {
+			m.addCellIssue(r, fs.count, "sample skipped, invalid system count", err)
+			continue
+		}
+		md := float64(fr.cfg.DefaultMaxDistance)
+		if fs.maxdistance >= 0 && !g.IsEmpty(r, fs.maxdistance) {
+			if md, err = g.Float(r, fs.maxdistance); err != nil {
+				m.addCellIssue(r, fs.maxdistance, "sample skipped, invalid max distance", err)
+				continue
+			}
+		}
+		m.SurveyPoints = append(m.SurveyPoints, SurveyPoint{
+			SystemName: strings.TrimSpace(g.String(r, fs.system)),
+			ZSample: float32(z),
+			Count: c,
+			MaxDistance: float32(md),
+			Row: r,
+		})
+	}
+	m.addAmbiguousCells(g)
+	if len(m.SurveyPoints) == 0 {
+		return m, fmt.Errorf("%s/%s: %w", g.spreadsheetID, m.Name, errNoSamples)
+	}
+
+	return m, nil
+}
+
+// serialTime converts a spreadsheet date serial number, the days since
+// 1899-12-30 in the spreadsheet's time zone
+func serialTime(serial float64, loc *time.Location) time.Time {
+	secs := int(math.Round(serial * 86400))
+	return time.Date(1899, 12, 30, 0, 0, secs, 0, loc)
+}
@@ -0,0 +1,103 @@
+package densitysurvey
+
+import (
+	"regexp"
+	"strings"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+const (
+	// the grid data needed for the values and the links of the cells
+	linkFields = "sheets(data(rowData(values(effectiveValue,formattedValue,hyperlink," +
+		"textFormatRuns(format(link(uri))),chipRuns(chip(richLinkProperties(uri)))," +
+		"userEnteredValue(formulaValue)))))"
+)
+
+var (
+	// the URL argument of =HYPERLINK("url"; "label"), also in longer formulas
+	hyperlinkFormula = regexp.MustCompile(`(?i)HYPERLINK\(\s*"([^"]+)"`)
+	urlText = regexp.MustCompile(`https?://[^\s"<>]+`)
+)
+
+// cellLinks are the targets of all the links of a cell: its hyperlink, the
+// links of its rich text runs and chips, the HYPERLINK formulas and the
+// URLs typed in its text, in this order, without duplicates
+func cellLinks(cd *sheets.CellData) []string {
+	ret := []string{}
+	seen := map[string]bool{}
+	add := func(link string) {
+		link = strings.TrimSpace(link)
+		if link != "" && !seen[link] {
+			seen[link] = true
+			ret = append(ret, link)
+		}
+	}
+	if cd == nil {
+		return ret
+	}
+
+	add(cd.Hyperlink)
+	for _, run := range cd.TextFormatRuns {
+		if run.Format != nil && run.Format.Link != nil {
+			add(run.Format.Link.Uri)
+		}
+	}
+	for _, run := range cd.ChipRuns {
+		if run.Chip != nil && run.Chip.RichLinkProperties != nil {
+			add(run.Chip.RichLinkProperties.Uri)
+		}
+	}
+	if cd.UserEnteredValue != nil && cd.UserEnteredValue.FormulaValue != nil {
+		for _, m := range hyperlinkFormula.FindAllStringSubmatch(*cd.UserEnteredValue.FormulaValue, -1) {
+			add(m[1])
+		}
+	}
+	for _, link := range urlText.FindAllString(cd.FormattedValue, -1) {
+		add(link)
+	}
+
+	return ret
+}
+
+// gridValues are the unformatted values of the rows, as the values API
+// returns them
+func gridValues(rows []*sheets.RowData) [][]interface{} {
+	ret := make([][]interface{}, len(rows))
+	for i, row := range rows {
+		if row == nil {
+			continue
+		}
+		ret[i] = make([]interface{}, len(row.Values))
+		for j, cd := range row.Values {
+			if cd != nil {
+				ret[i][j] = extendedValue(cd.EffectiveValue)
+			}
+		}
+	}
+	return ret
+}
+
+func extendedValue(v *sheets.ExtendedValue) interface{} {
+	switch {
+	case v == nil:
+		return nil
+	case v.NumberValue != nil:
+		return *v.NumberValue
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return *v.BoolValue
+	case v.ErrorValue != nil:
+		return v.ErrorValue.Message
+	}
+	return nil
+}
+
+// gridCell is a cell of the rows, nil if it's out of the data
+func gridCell(rows []*sheets.RowData, row, col int) *sheets.CellData {
+	if row < 0 || row >= len(rows) || rows[row] == nil || col < 0 || col >= len(rows[row].Values) {
+		return nil
+	}
+	return rows[row].Values[col]
+}
@@ -0,0 +1,159 @@
+package densitysurvey
+
+import (
+	"slices"
+	"testing"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+const (
+	testID = "1AbCdEfGhIjKlMnOpQrStUvWxYz0123456789_-abcd"
+	otherID = "1ZyXwVuTsRqPoNmLkJiHgFeDcBa9876543210_-zyxw"
+)
+
+func TestSpreadsheetID(t *testing.T) {
+	tests := []struct {
+		in string
+		want string
+		wantErr bool
+	}{
+		{testID, testID, false},
+		{"  " + testID + "\n", testID, false},
+		{"https://docs.google.com/spreadsheets/d/" + testID + "/edit#gid=0", testID, false},
+		{"https://docs.google.com/spreadsheets/d/" + testID + "/edit?usp=sharing", testID, false},
+		{"https://drive.google.com/open?id=" + testID, testID, false},
+		{"https://example.com/spreadsheets/d/" + testID, "", true},
+		{"https://docs.google.com/spreadsheets/d/short/edit", "", true},
+		{"https://drive.google.com/open?id=short", "", true},
+		{"not a link", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		got, err := SpreadsheetID(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("SpreadsheetID(%q) = %q, %v, want %q, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCellLinks(t *testing.T) {
+	sheetURL := "https://docs.google.com/spreadsheets/d/" + testID + "/edit"
+	otherURL := "https://docs.google.com/spreadsheets/d/" + otherID + "/edit"
+	formula := `=HYPERLINK("` + otherURL + `"; "survey")`
+
+	tests := []struct {
+		name string
+		cd *sheets.CellData
+		want []string
+	}{
+		{"nil cell", nil, []string{}},
+		{"plain text", &sheets.CellData{FormattedValue: "no link here"}, []string{}},
+		{"hyperlink", &sheets.CellData{Hyperlink: sheetURL}, []string{sheetURL}},
+		{
+			name: "text runs",
+			cd: &sheets.CellData{TextFormatRuns: []*sheets.TextFormatRun{
+				{Format: &sheets.TextFormat{Link: &sheets.Link{Uri: sheetURL}}},
+				{Format: &sheets.TextFormat{}},
+				{StartIndex: 10},
+				{Format: &sheets.TextFormat{Link: &sheets.Link{Uri: otherURL}}},
+			}},
+			want: []string{sheetURL, otherURL},
+		},
+		{
+			name: "chip",
+			cd: &sheets.CellData{ChipRuns: []*sheets.ChipRun{
+				{},
+				{Chip: &sheets.Chip{RichLinkProperties: &sheets.RichLinkProperties{Uri: sheetURL}}},
+			}},
+			want: []string{sheetURL},
+		},
+		{
+			name: "formula",
+			cd: &sheets.CellData{UserEnteredValue: &sheets.ExtendedValue{FormulaValue: &formula}},
+			want: []string{otherURL},
+		},
+		{
+			name: "typed URLs",
+			cd: &sheets.CellData{FormattedValue: "first " + sheetURL + " second <" + otherURL + ">"},
+			want: []string{sheetURL, otherURL},
+		},
+		{
+			name: "in order without duplicates",
+			cd: &sheets.CellData{
+				Hyperlink: otherURL,
+				TextFormatRuns: []*sheets.TextFormatRun{
+					{Format: &sheets.TextFormat{Link: &sheets.Link{Uri: " " + otherURL}}},
+				},
+				UserEnteredValue: &sheets.ExtendedValue{FormulaValue: &formula},
+				FormattedValue: sheetURL,
+			},
+			want: []string{otherURL, sheetURL},
+		},
+	}
+	for _, tt := range tests {
+		if got := cellLinks(tt.cd); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: links %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEntryIDs(t *testing.T) {
+	sheetURL := "https://docs.google.com/spreadsheets/d/" + testID + "/edit"
+	otherURL := "https://drive.google.com/open?id=" + otherID
+
+	tests := []struct {
+		name string
+		text string
+		links []string
+		want []string
+		wantErr bool
+	}{
+		{"bare ID", testID, nil, []string{testID}, false},
+		{"link in the text", sheetURL, nil, []string{testID}, false},
+		{"links before the text", "survey", []string{sheetURL, otherURL}, []string{testID, otherID}, false},
+		{"duplicates", "", []string{sheetURL, sheetURL + "#gid=1"}, []string{testID}, false},
+		// the other links are reported, but the ID is kept
+		{"mixed", "", []string{"https://example.com/x", sheetURL}, []string{testID}, true},
+		{"nothing", "see the other row", nil, []string{}, true},
+	}
+	for _, tt := range tests {
+		got, err := entryIDs(tt.text, tt.links)
+		if !slices.Equal(got, tt.want) || (err != nil) != tt.wantErr {
+			t.Errorf("%s: IDs %v, %v, want %v, error %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestGridValues(t *testing.T) {
+	num := 12.5
+	str := "Sol"
+	yes := true
+	rows := []*sheets.RowData{
+		{Values: []*sheets.CellData{
+			{EffectiveValue: &sheets.ExtendedValue{NumberValue: &num}},
+			{EffectiveValue: &sheets.ExtendedValue{StringValue: &str}},
+			nil,
+			{EffectiveValue: &sheets.ExtendedValue{BoolValue: &yes}},
+			{EffectiveValue: &sheets.ExtendedValue{ErrorValue: &sheets.ErrorValue{Message: "#REF!"}}},
+			{},
+		}},
+		nil,
+	}
+
+	got := gridValues(rows)
+	want := []interface{}{12.5, "Sol", nil, true, "#REF!", nil}
+	if len(got) != 2 || len(got[0]) != len(want) || got[1] != nil {
+		t.Fatalf("values %v", got)
+	}
+	for i := range want {
+		if got[0][i] != want[i] {
+			t.Errorf("value %d: %v, want %v", i, got[0][i], want[i])
+		}
+	}
+
+	if gridCell(rows, 0, 1) != rows[0].Values[1] || gridCell(rows, 1, 0) != nil || gridCell(rows, 0, 6) != nil ||
+		gridCell(rows, 2, 0) != nil || gridCell(rows, -1, 0) != nil {
+		t.Errorf("gridCell")
+	}
+}
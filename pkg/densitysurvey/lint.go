@@ -0,0 +1,171 @@
+package densitysurvey
+
+import (
+	"io"
+	"fmt"
+	"errors"
+)
+
+// VariantCheck is the outcome of trying a sheet variant on a tab
+type VariantCheck struct {
+	Variant string `json:"variant"`
+	Matched bool `json:"matched"`
+	// why it didn't match
+	Reason string `json:"reason,omitempty"`
+	// the rows with a z-sample and the valid samples of them, -1 when the
+	// headers didn't match
+	Rows int `json:"rows"`
+	Samples int `json:"samples"`
+	MinSampleRatio float32 `json:"minsampleratio"`
+
+	err error
+}
+
+func newVariantCheck(sv *sheetVariant, g *cellGrid, err error) VariantCheck {
+	vc := VariantCheck{
+		Variant: sv.Name,
+		Matched: err == nil,
+		Rows: -1,
+		Samples: -1,
+		MinSampleRatio: sv.MinSampleRatio,
+		err: err,
+	}
+
+	var tfs *TooFewSamplesError
+	switch {
+	case err == nil:
+		vc.Rows, vc.Samples = sampleCounts(sv, g)
+	case errors.As(err, &tfs):
+		vc.Reason = err.Error()
+		vc.Rows, vc.Samples = tfs.Rows, tfs.Samples
+	default:
+		vc.Reason = err.Error()
+	}
+	return vc
+}
+
+// Ratio is the ratio of the valid samples, -1 if it's not known
+func (vc *VariantCheck) Ratio() float32 {
+	if vc.Rows < 0 {
+		return -1
+	}
+	if vc.Rows == 0 {
+		return 0
+	}
+	return float32(vc.Samples) / float32(vc.Rows)
+}
+
+// TabLint explains how a tab is parsed: the variants tried, the rows
+// skipped, and after the coordinate lookup the systems not resolved and
+// the points flagged
+type TabLint struct {
+	Tab string `json:"tab"`
+	// the variant matched, empty if none
+	Variant string `json:"variant,omitempty"`
+	CMDR string `json:"cmdr,omitempty"`
+	Project string `json:"project,omitempty"`
+	Checks []VariantCheck `json:"checks"`
+	Points int `json:"points"`
+	Skipped []string `json:"skipped"`
+	Unresolved []string `json:"unresolved"`
+	Flags []string `json:"flags"`
+	// why the tab couldn't be parsed, or the lookup failed
+	Errors []string `json:"errors"`
+
+	// the survey parsed, nil if it couldn't be
+	Survey *Survey `json:"-"`
+}
+
+// LintReport is the outcome of linting a spreadsheet
+type LintReport struct {
+	SpreadsheetID string `json:"spreadsheetid"`
+	Tabs []TabLint `json:"tabs"`
+}
+
+// Lint parses the tabs like Parse does, explaining the outcome. The results
+// sheets written by the cli are left out.
+func (p *Parser) Lint(tabs []RawTab) []TabLint {
+	ret := []TabLint{}
+
+	for i := range tabs {
+		t := &tabs[i]
+		m, checks, err := p.parseTab(t)
+		if errors.Is(err, errResultsSheet) {
+			continue
+		}
+		tl := TabLint{
+			Tab: t.Tab,
+			Variant: m.Variant,
+			CMDR: m.CMDR,
+			Project: m.Project,
+			Checks: checks,
+			Points: len(m.SurveyPoints),
+			Skipped: []string{},
+			Unresolved: []string{},
+			Flags: []string{},
+			Errors: []string{},
+		}
+		for _, issue := range m.Issues {
+			tl.Skipped = append(tl.Skipped, issue.String())
+		}
+		if err != nil {
+			tl.Errors = append(tl.Errors, err.Error())
+		} else {
+			tl.Survey = &m
+			for _, f := range m.ValidateSchedule() {
+				tl.Flags = append(tl.Flags, f.String())
+			}
+		}
+		ret = append(ret, tl)
+	}
+
+	return ret
+}
+
+func (issue CellIssue) String() string {
+	msg := fmt.Sprintf("%s: %s", cellRef(issue.Row, issue.Column), issue.Message)
+	if issue.Err != nil {
+		msg += fmt.Sprintf(": %v", issue.Err)
+	}
+	return msg
+}
+
+// Write prints the human readable lint report
+func (r *LintReport) Write(w io.Writer) {
+	fmt.Fprintf(w, "=== %s ===\n", r.SpreadsheetID)
+	for _, tl := range r.Tabs {
+		switch {
+		case tl.Variant == "":
+			fmt.Fprintf(w, "%s: not a survey, no variant matched\n", tl.Tab)
+		case len(tl.Errors) > 0 && tl.Survey == nil:
+			fmt.Fprintf(w, "%s: variant %s, not parsed\n", tl.Tab, tl.Variant)
+		default:
+			fmt.Fprintf(w, "%s: variant %s, %s - %s, points:%d\n", tl.Tab, tl.Variant,
+				tl.CMDR, tl.Project, tl.Points)
+		}
+		for _, vc := range tl.Checks {
+			ratio := ""
+			if r := vc.Ratio(); r >= 0 {
+				ratio = fmt.Sprintf(", samples %d of %d rows, ratio %.2f, needs above %.2f", vc.Samples,
+					vc.Rows, r, vc.MinSampleRatio)
+			}
+			if vc.Matched {
+				fmt.Fprintf(w, "  variant %s: matched%s\n", vc.Variant, ratio)
+			} else {
+				fmt.Fprintf(w, "  variant %s: %s\n", vc.Variant, vc.Reason)
+			}
+		}
+		for _, msg := range tl.Skipped {
+			fmt.Fprintf(w, "  issue: %s\n", msg)
+		}
+		for _, name := range tl.Unresolved {
+			fmt.Fprintf(w, "  unresolved: %s\n", name)
+		}
+		for _, f := range tl.Flags {
+			fmt.Fprintf(w, "  flag: %s\n", f)
+		}
+		for _, msg := range tl.Errors {
+			fmt.Fprintf(w, "  error: %s\n", msg)
+		}
+	}
+}
@@ -1,58 +1,148 @@
 package densitysurvey
 
 import (
-	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/edsm"
-)
-
-var (
-	// in cli-mode having a global state is a workaround
-	edsms *edsm.EDSM
+	"errors"
+	"time"
+	"context"
+	"strings"
 )
 
 type Survey struct {
 	CMDR string
 	Project string
+	// the sheet (tab) it's from
 	Name string
+	SpreadsheetID string
+	// the name of the sheet variant the survey was parsed with
+	Variant string
 	SurveyPoints []SurveyPoint
+	// survey level validation flags
+	Flags []string
+	// problems found while parsing, with their cells
+	Issues []CellIssue
+	// when and by whom the form response was submitted, unset for sheets
+	Submitted *time.Time
+	Respondent string
+	// the date of the survey and the CMDR's notes, if the sheet has them
+	Date *time.Time
+	Notes string
+	// the RawTab it was parsed from, 0 if it's not archived
+	ArchiveID int
+	// the configured source it was ingested from, empty for the default one
+	Source string
+
+	// the expected heights of the variant, if any
+	schedule *sampleSchedule
+	// the variant the survey was parsed with, nil if it wasn't from a sheet
+	variant *sheetVariant
+}
+
+// RetractedSurvey is a stored survey whose spreadsheet or tab disappeared
+type RetractedSurvey struct {
+	ID int
+	CMDR string
+	Project string
+	SpreadsheetID string
+	Tab string
+	Retracted time.Time
+}
+
+// CellIssue is a problem with a cell of the survey's sheet, Row and Column
+// are 0-based
+type CellIssue struct {
+	Row int
+	Column int
+	Message string
+	// the conversion error, if it was one
+	Err *CellError
+}
+
+func (m *Survey) addCellIssue(row, col int, msg string, err error) {
+	issue := CellIssue{
+		Row: row,
+		Column: col,
+		Message: msg,
+	}
+	errors.As(err, &issue.Err)
+	m.Issues = append(m.Issues, issue)
+}
+
+// addAmbiguousCells reports the numbers read from the grid with an
+// ambiguous comma
+func (m *Survey) addAmbiguousCells(g *cellGrid) {
+	for _, p := range g.ambiguousCells() {
+		m.addCellIssue(p.row, p.col, "read as a decimal number",
+			g.cellError(p.row, p.col, errAmbiguousNumber))
+	}
 }
 
 type SurveyPoint struct {
 	X float32
 	Y float32
 	Z float32
+	// whether X/Y/Z are known, unresolved points are stored without coordinates
+	Resolved bool
+	// where X/Y/Z are coming from, see the CoordSource* constants
+	CoordSource string
+	// the coordinates filled in the survey sheet, if any
+	SheetCoordinates *Coordinates
 	SystemName string
-	ZSample int
+	// the height the point is supposed to be at, logarithmic surveys have
+	// non-integer and negative ones as well
+	ZSample float32
 	Count int
 	MaxDistance float32
+	// validation flags, see the Flag* constants
+	Flags []string
+	// the 0-based row in the survey sheet
+	Row int
 }
 
-func (m *Survey) LookupNames() error {
-
-	if edsms == nil {
-		edsms = edsm.New()
-	}
+// LookupNames resolves the coordinates of the survey points through the
+// given resolver. Points which couldn't be resolved
+// fall back to the coordinates from the sheet, if there's none they are
+// left with Resolved=false.
+func (m *Survey) LookupNames(ctx context.Context, r CoordinateResolver) error {
 
 	names := make([]string, 0, len(m.SurveyPoints))
 	for _, dp := range m.SurveyPoints {
-		names = append(names, dp.SystemName)
+		if !dp.Resolved || dp.CoordSource == CoordSourceSheet {
+			names = append(names, dp.SystemName)
+		}
 	}
-
-	lookupres, err := edsms.Systems(names)
-	if err != nil {
-		return err
+	if len(names) == 0 {
+		return nil
 	}
 
-	// and correlate names
+	lookupres, err := r.Resolve(ctx, names)
+
+	// and correlate names, even on partial failures
 	for i, dp := range m.SurveyPoints {
-		for _, sys := range lookupres {
-			if sys.Name == dp.SystemName {
-				m.SurveyPoints[i].X = sys.Coords.X
-				m.SurveyPoints[i].Y = sys.Coords.Z
-				m.SurveyPoints[i].Z = sys.Coords.Y
-				break
-			}
+		if c, ok := lookupres[strings.ToLower(dp.SystemName)]; ok {
+			m.SurveyPoints[i].SetCoordinates(c)
+		} else if !dp.Resolved && dp.SheetCoordinates != nil {
+			m.SurveyPoints[i].SetCoordinates(*dp.SheetCoordinates)
 		}
 	}
 
-	return nil
+	return err
+}
+
+func (dp *SurveyPoint) SetCoordinates(c Coordinates) {
+	dp.X = c.X
+	dp.Y = c.Y
+	dp.Z = c.Z
+	dp.CoordSource = c.Source
+	dp.Resolved = true
+}
+
+// Unresolved returns the names of the systems without coordinates
+func (m *Survey) Unresolved() []string {
+	ret := []string{}
+	for _, dp := range m.SurveyPoints {
+		if !dp.Resolved {
+			ret = append(ret, dp.SystemName)
+		}
+	}
+	return ret
 }
@@ -0,0 +1,181 @@
+package densitysurvey
+
+import (
+	"fmt"
+	"time"
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/config"
+)
+
+const (
+	// the in-game calendar is ahead of the real one by this many years
+	gameYearOffset = 1286
+)
+
+// metadataExtractor finds the CMDR, the project, the date and the notes
+// of a survey, see config.MetadataConfig
+type metadataExtractor struct {
+	a1 *regexp.Regexp
+	tab *regexp.Regexp
+	title *regexp.Regexp
+	// 0-based, -1 when not set
+	dateRow, dateColumn int
+	notesRow, notesColumn int
+	dateFormats []string
+}
+
+func newMetadataExtractor(cfg *config.MetadataConfig) (*metadataExtractor, error) {
+	var err error
+	me := &metadataExtractor{
+		dateRow: -1,
+		dateColumn: -1,
+		notesRow: -1,
+		notesColumn: -1,
+		dateFormats: cfg.DateFormats,
+	}
+
+	patterns := []struct{
+		name string
+		pattern string
+		dst **regexp.Regexp
+	}{
+		{"a1", cfg.A1, &me.a1},
+		{"tab", cfg.Tab, &me.tab},
+		{"title", cfg.Title, &me.title},
+	}
+	for _, p := range patterns {
+		if p.pattern == "" {
+			continue
+		}
+		if *p.dst, err = regexp.Compile(p.pattern); err != nil {
+			return nil, fmt.Errorf("metadata.%s: %w", p.name, err)
+		}
+	}
+
+	if cfg.DateCell != "" {
+		if me.dateRow, me.dateColumn, err = parseCell(cfg.DateCell); err != nil {
+			return nil, fmt.Errorf("metadata.datecell: %w", err)
+		}
+	}
+	if cfg.NotesCell != "" {
+		if me.notesRow, me.notesColumn, err = parseCell(cfg.NotesCell); err != nil {
+			return nil, fmt.Errorf("metadata.notescell: %w", err)
+		}
+	}
+
+	return me, nil
+}
+
+// lastColumn is the last column of the metadata cells, -1 without them
+func (me *metadataExtractor) lastColumn() int {
+	return max(me.dateColumn, me.notesColumn)
+}
+
+// extract fills the survey's metadata left empty by the overrides: the
+// date and notes cells first, then A1, the tab's and the spreadsheet's
+// title, each of them only for the fields still missing. Returns why the
+// patterns didn't match, joined.
+func (me *metadataExtractor) extract(m *Survey, g *cellGrid, t *RawTab) error {
+	var tried error = nil
+
+	if me.dateRow >= 0 && !g.IsEmpty(me.dateRow, me.dateColumn) {
+		if d, err := me.cellDate(g); err != nil {
+			m.addCellIssue(me.dateRow, me.dateColumn, "survey date not recognized", err)
+		} else {
+			m.Date = &d
+		}
+	}
+	if me.notesRow >= 0 {
+		m.Notes = strings.TrimSpace(g.String(me.notesRow, me.notesColumn))
+	}
+
+	sources := []struct{
+		what string
+		re *regexp.Regexp
+		text string
+	}{
+		{"A1", me.a1, g.String(0, 0)},
+		{"the tab's title", me.tab, t.Tab},
+		{"the spreadsheet's title", me.title, t.Title},
+	}
+	for _, src := range sources {
+		if m.CMDR != "" && m.Project != "" && m.Date != nil && m.Notes != "" {
+			break
+		}
+		if src.re == nil {
+			continue
+		}
+		groups := matchGroups(src.re, src.text)
+		if groups == nil {
+			tried = errors.Join(tried, fmt.Errorf("%s '%s' doesn't match '%s'", src.what, src.text, src.re))
+			continue
+		}
+		if m.CMDR == "" {
+			m.CMDR = groups["cmdr"]
+		}
+		if m.Project == "" {
+			m.Project = groups["project"]
+		}
+		if m.Notes == "" {
+			m.Notes = groups["notes"]
+		}
+		if m.Date == nil && groups["date"] != "" {
+			if d, err := me.parseDate(groups["date"]); err == nil {
+				m.Date = &d
+			}
+		}
+	}
+
+	return tried
+}
+
+// matchGroups is the trimmed named groups of the match, nil if it doesn't
+// match
+func matchGroups(re *regexp.Regexp, text string) map[string]string {
+	match := re.FindStringSubmatch(text)
+	if match == nil {
+		return nil
+	}
+	ret := map[string]string{}
+	for i, name := range re.SubexpNames() {
+		if name != "" {
+			ret[name] = strings.TrimSpace(match[i])
+		}
+	}
+	return ret
+}
+
+// cellDate is the date of the date cell, either a date value or text in
+// one of the formats
+func (me *metadataExtractor) cellDate(g *cellGrid) (time.Time, error) {
+	if serial, ok := g.Raw(me.dateRow, me.dateColumn).(float64); ok {
+		return gameDate(serialTime(serial, time.UTC)), nil
+	}
+	d, err := me.parseDate(g.String(me.dateRow, me.dateColumn))
+	if err != nil {
+		return d, g.cellError(me.dateRow, me.dateColumn, err)
+	}
+	return d, nil
+}
+
+// parseDate parses the date in the first matching format
+func (me *metadataExtractor) parseDate(text string) (time.Time, error) {
+	text = strings.TrimSpace(text)
+	for _, layout := range me.dateFormats {
+		if d, err := time.Parse(layout, text); err == nil {
+			return gameDate(d), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("'%s' is not a date in the formats %v", text, me.dateFormats)
+}
+
+// gameDate converts the in-game dates to the real calendar
+func gameDate(d time.Time) time.Time {
+	if d.Year() >= 3300 {
+		return d.AddDate(-gameYearOffset, 0, 0)
+	}
+	return d
+}
@@ -0,0 +1,104 @@
+package densitysurvey
+
+import (
+	"errors"
+	"context"
+	"strings"
+
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/edsm"
+)
+
+// Coordinates of a system in the analyzer's orientation, where Z is the
+// height above/below the galactic plane
+type Coordinates struct {
+	X float32
+	Y float32
+	Z float32
+	// where the coordinates are coming from, see the CoordSource* constants
+	Source string
+}
+
+const (
+	CoordSourceEDSM = "edsm"
+	CoordSourceCache = "cache"
+	CoordSourceSheet = "sheet"
+)
+
+// CoordinateResolver looks up the coordinates of systems by name.
+// Systems it doesn't know are simply missing from the result, the map
+// is keyed by the lowercased system name.
+type CoordinateResolver interface {
+	Resolve(ctx context.Context, names []string) (map[string]Coordinates, error)
+}
+
+// ResolverChain asks its resolvers in order, each one only for the
+// names the previous ones couldn't resolve
+type ResolverChain []CoordinateResolver
+
+func (rc ResolverChain) Resolve(ctx context.Context, names []string) (map[string]Coordinates, error) {
+	var reterr error = nil
+	ret := map[string]Coordinates{}
+
+	pending := names
+	for _, r := range rc {
+		if len(pending) == 0 {
+			break
+		}
+
+		if err := ctx.Err(); err != nil {
+			return ret, errors.Join(reterr, err)
+		}
+		res, err := r.Resolve(ctx, pending)
+		if err != nil {
+			reterr = errors.Join(reterr, err)
+		}
+
+		left := make([]string, 0, len(pending))
+		for _, name := range pending {
+			key := strings.ToLower(name)
+			if c, ok := res[key]; ok {
+				ret[key] = c
+			} else {
+				left = append(left, name)
+			}
+		}
+		pending = left
+	}
+
+	return ret, reterr
+}
+
+// EDSMResolver resolves the names through EDSM's systems API
+type EDSMResolver struct {
+	client *edsm.EDSM
+}
+
+func NewEDSMResolver(client *edsm.EDSM) *EDSMResolver {
+	return &EDSMResolver{
+		client: client,
+	}
+}
+
+func (r *EDSMResolver) Resolve(ctx context.Context, names []string) (map[string]Coordinates, error) {
+	ret := map[string]Coordinates{}
+
+	lookupres, err := r.client.Systems(ctx, names)
+	if err != nil {
+		return ret, err
+	}
+
+	for _, sys := range lookupres {
+		if sys.Coords == nil {
+			continue
+		}
+		// EDSM has Y as the vertical axis
+		ret[strings.ToLower(sys.Name)] = Coordinates{
+			X: sys.Coords.X,
+			Y: sys.Coords.Z,
+			Z: sys.Coords.Y,
+			Source: CoordSourceEDSM,
+		}
+	}
+
+	return ret, nil
+}
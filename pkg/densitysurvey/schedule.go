@@ -0,0 +1,146 @@
+package densitysurvey
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/config"
+)
+
+const (
+	ScheduleLinear = "linear"
+	ScheduleLogarithmic = "logarithmic"
+)
+
+// sampleSchedule is the set of heights a survey is expected to sample
+type sampleSchedule struct {
+	Type string
+	// the expected heights, ascending
+	Heights []float64
+	// linear: in ly, logarithmic: relative to the height
+	Tolerance float64
+}
+
+// newSampleSchedule generates the heights of a schedule, nil if none is configured
+func newSampleSchedule(cfg *config.ScheduleConfig) (*sampleSchedule, error) {
+	if cfg.Type == "" {
+		return nil, nil
+	}
+	if cfg.Count < 1 {
+		return nil, fmt.Errorf("schedule count has to be at least 1, got %d", cfg.Count)
+	}
+	if cfg.Tolerance < 0 {
+		return nil, fmt.Errorf("schedule tolerance can't be negative, got %v", cfg.Tolerance)
+	}
+
+	ss := &sampleSchedule{
+		Type: cfg.Type,
+		Tolerance: float64(cfg.Tolerance),
+	}
+
+	var heights []float64
+	switch cfg.Type {
+	case ScheduleLinear:
+		if cfg.Step <= 0 {
+			return nil, fmt.Errorf("linear schedule step has to be positive, got %v", cfg.Step)
+		}
+		for i := 0; i < cfg.Count; i += 1 {
+			heights = append(heights, float64(cfg.Start)+float64(i)*float64(cfg.Step))
+		}
+	case ScheduleLogarithmic:
+		if cfg.Start <= 0 {
+			return nil, fmt.Errorf("logarithmic schedule start has to be positive, got %v", cfg.Start)
+		}
+		if cfg.Factor <= 1 {
+			return nil, fmt.Errorf("logarithmic schedule factor has to be above 1, got %v", cfg.Factor)
+		}
+		for i := 0; i < cfg.Count; i += 1 {
+			heights = append(heights, float64(cfg.Start)*math.Pow(float64(cfg.Factor), float64(i)))
+		}
+	default:
+		return nil, fmt.Errorf("unknown schedule type %s", cfg.Type)
+	}
+
+	// mirrored below the plane
+	if cfg.Signed {
+		for _, h := range heights {
+			if h > 0 {
+				ss.Heights = append(ss.Heights, -h)
+			}
+		}
+		// ascending order
+		for i, j := 0, len(ss.Heights)-1; i < j; i, j = i+1, j-1 {
+			ss.Heights[i], ss.Heights[j] = ss.Heights[j], ss.Heights[i]
+		}
+	}
+	if cfg.Zero && (len(heights) == 0 || heights[0] != 0) {
+		ss.Heights = append(ss.Heights, 0)
+	}
+	ss.Heights = append(ss.Heights, heights...)
+
+	return ss, nil
+}
+
+// match returns the index of the scheduled height z belongs to, -1 if none
+func (ss *sampleSchedule) match(z float64) int {
+	for i, h := range ss.Heights {
+		tolerance := ss.Tolerance
+		if ss.Type == ScheduleLogarithmic {
+			tolerance *= math.Abs(h)
+		}
+		if math.Abs(z-h) <= tolerance {
+			return i
+		}
+	}
+	return -1
+}
+
+// ValidateSchedule checks the z-samples of the points against the expected
+// heights of the survey's variant. Points off the schedule are flagged, and
+// the survey is flagged when scheduled heights are missing. Surveys of
+// variants without a schedule are not checked.
+func (m *Survey) ValidateSchedule() []ValidationFlag {
+	flags := []ValidationFlag{}
+	m.Flags = removeFlags(m.Flags, FlagMissingHeights)
+	for i := range m.SurveyPoints {
+		m.SurveyPoints[i].clearFlags(FlagOffSchedule)
+	}
+
+	if m.schedule == nil {
+		return flags
+	}
+
+	seen := make([]bool, len(m.schedule.Heights))
+	for i, dp := range m.SurveyPoints {
+		idx := m.schedule.match(float64(dp.ZSample))
+		if idx < 0 {
+			m.SurveyPoints[i].addFlag(FlagOffSchedule)
+			flags = append(flags, ValidationFlag{
+				SystemName: dp.SystemName,
+				Flag: FlagOffSchedule,
+				Detail: fmt.Sprintf("zsample=%g is not on the %s schedule", dp.ZSample, m.schedule.Type),
+			})
+			continue
+		}
+		seen[idx] = true
+	}
+
+	missing := []string{}
+	for i, h := range m.schedule.Heights {
+		if !seen[i] {
+			missing = append(missing, fmt.Sprintf("%.4g", h))
+		}
+	}
+	if len(missing) > 0 {
+		m.Flags = append(m.Flags, FlagMissingHeights)
+		flags = append(flags, ValidationFlag{
+			SystemName: m.Name,
+			Flag: FlagMissingHeights,
+			Detail: fmt.Sprintf("%d of %d scheduled heights missing: %s", len(missing),
+				len(m.schedule.Heights), strings.Join(missing, ", ")),
+		})
+	}
+
+	return flags
+}
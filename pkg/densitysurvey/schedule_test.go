@@ -0,0 +1,172 @@
+package densitysurvey
+
+import (
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/config"
+)
+
+func TestNewSampleSchedule(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg config.ScheduleConfig
+		want []float64
+		wantErr bool
+	}{
+		{
+			name: "none",
+			cfg: config.ScheduleConfig{},
+		},
+		{
+			name: "linear",
+			cfg: config.ScheduleConfig{Type: ScheduleLinear, Start: 0, Step: 50, Count: 4},
+			want: []float64{0, 50, 100, 150},
+		},
+		{
+			name: "logarithmic signed with zero",
+			cfg: config.ScheduleConfig{Type: ScheduleLogarithmic, Start: 10, Factor: 10, Count: 3,
+				Signed: true, Zero: true},
+			want: []float64{-1000, -100, -10, 0, 10, 100, 1000},
+		},
+		{
+			name: "linear from zero isn't mirrored at zero",
+			cfg: config.ScheduleConfig{Type: ScheduleLinear, Start: 0, Step: 100, Count: 2,
+				Signed: true, Zero: true},
+			want: []float64{-100, 0, 100},
+		},
+		{
+			name: "count",
+			cfg: config.ScheduleConfig{Type: ScheduleLinear, Step: 1},
+			wantErr: true,
+		},
+		{
+			name: "linear step",
+			cfg: config.ScheduleConfig{Type: ScheduleLinear, Count: 3},
+			wantErr: true,
+		},
+		{
+			name: "logarithmic start",
+			cfg: config.ScheduleConfig{Type: ScheduleLogarithmic, Factor: 2, Count: 3},
+			wantErr: true,
+		},
+		{
+			name: "logarithmic factor",
+			cfg: config.ScheduleConfig{Type: ScheduleLogarithmic, Start: 1, Factor: 1, Count: 3},
+			wantErr: true,
+		},
+		{
+			name: "negative tolerance",
+			cfg: config.ScheduleConfig{Type: ScheduleLinear, Step: 1, Count: 3, Tolerance: -1},
+			wantErr: true,
+		},
+		{
+			name: "unknown type",
+			cfg: config.ScheduleConfig{Type: "cubic", Count: 3},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ss, err := newSampleSchedule(&tt.cfg)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("no error, heights %v", ss.Heights)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == nil {
+				if ss != nil {
+					t.Errorf("schedule %+v, want none", ss)
+				}
+				return
+			}
+			if len(ss.Heights) != len(tt.want) {
+				t.Fatalf("heights %v, want %v", ss.Heights, tt.want)
+			}
+			for i := range tt.want {
+				if math.Abs(ss.Heights[i]-tt.want[i]) > 1e-6 {
+					t.Errorf("heights %v, want %v", ss.Heights, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestSampleScheduleMatch(t *testing.T) {
+	logarithmic := &sampleSchedule{
+		Type: ScheduleLogarithmic,
+		Heights: []float64{-100, -10, 10, 100},
+		Tolerance: 0.05,
+	}
+	linear := &sampleSchedule{
+		Type: ScheduleLinear,
+		Heights: []float64{0, 50, 100},
+		Tolerance: 2,
+	}
+
+	tests := []struct {
+		ss *sampleSchedule
+		z float64
+		want int
+	}{
+		{logarithmic, 10, 2},
+		{logarithmic, 10.4, 2},
+		{logarithmic, 10.6, -1},
+		// the tolerance scales with the height
+		{logarithmic, 104, 3},
+		{logarithmic, -96, 0},
+		{logarithmic, 0, -1},
+		{linear, 0, 0},
+		{linear, 51.5, 1},
+		{linear, 53, -1},
+	}
+	for _, tt := range tests {
+		if got := tt.ss.match(tt.z); got != tt.want {
+			t.Errorf("%s match(%v) = %d, want %d", tt.ss.Type, tt.z, got, tt.want)
+		}
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	m := Survey{
+		Name: "tab",
+		Flags: []string{FlagMissingHeights},
+		SurveyPoints: []SurveyPoint{
+			{SystemName: "a", ZSample: 10},
+			{SystemName: "b", ZSample: 33, Flags: []string{FlagOffSchedule}},
+			{SystemName: "c", ZSample: 1000, Flags: []string{FlagColumnDrift}},
+		},
+		schedule: &sampleSchedule{
+			Type: ScheduleLogarithmic,
+			Heights: []float64{10, 100, 1000},
+			Tolerance: 0.05,
+		},
+	}
+
+	flags := m.ValidateSchedule()
+	if len(flags) != 2 {
+		t.Errorf("flags %v, want the off-schedule b and the missing 100", flags)
+	}
+	if !slices.Equal(m.Flags, []string{FlagMissingHeights}) {
+		t.Errorf("survey flags %v", m.Flags)
+	}
+	want := [][]string{{}, {FlagOffSchedule}, {FlagColumnDrift}}
+	for i, dp := range m.SurveyPoints {
+		if !slices.Equal(dp.Flags, want[i]) {
+			t.Errorf("%s: flags %v, want %v", dp.SystemName, dp.Flags, want[i])
+		}
+	}
+
+	// without a schedule the flags of the previous runs are removed
+	m.schedule = nil
+	if flags = m.ValidateSchedule(); len(flags) != 0 || len(m.Flags) != 0 {
+		t.Errorf("flags %v, survey flags %v without a schedule", flags, m.Flags)
+	}
+}
@@ -1,6 +1,7 @@
 package densitysurvey
 
 import (
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/config"
 )
 
 var (
@@ -31,6 +32,41 @@ var (
 		ZColumn: 7,
 		YColumn: 8,
 		MinSampleRatio: 0.45,
+		DefaultMaxDistance: 20,
+	}
+
+	// same layout as DW3, with logarithmically spaced heights
+	variantDW3Log = sheetVariant{
+		Name: "DW3Log",
+		Project: "DW3 Logarithmic Density Scans",
+		HeaderRow: 4,
+		HeaderChecks: []sheetHeaderCheck{
+			sheetHeaderCheck{0, 4, "System"},
+			sheetHeaderCheck{2, 4, "System Count"},
+			sheetHeaderCheck{6, 4, "X"},
+			sheetHeaderCheck{7, 4, "Z"},
+			sheetHeaderCheck{8, 4, "Y"},
+		},
+		SampleIndicatorColumn: 1,
+		SysNameColumn: 0,
+		ZSampleColumn: 1,
+		SystemCountColumn: 2,
+		MaxDistanceColumn: 4,
+		XColumn: 6,
+		ZColumn: 7,
+		YColumn: 8,
+		MinSampleRatio: 0.45,
+		DefaultMaxDistance: 20,
+		// 10ly to 10kly, 4 steps per decade, on both sides
+		Schedule: mustSampleSchedule(&config.ScheduleConfig{
+			Type: ScheduleLogarithmic,
+			Start: 10,
+			Factor: 1.7782794,
+			Count: 13,
+			Signed: true,
+			Zero: true,
+			Tolerance: 0.05,
+		}),
 	}
 
 	variantA15X = sheetVariant{
@@ -60,10 +96,11 @@ var (
 		ZColumn: 6,
 		YColumn: 7,
 		MinSampleRatio: 0.9,
+		DefaultMaxDistance: 20,
 	}
 
 	variantA15Xv1 = sheetVariant{
-		Name: "A15X",
+		Name: "A15Xv1",
 		HeaderRow: 5,
 		HeaderChecks: []sheetHeaderCheck{
 			sheetHeaderCheck{
@@ -89,10 +126,11 @@ var (
 		ZColumn: 6,
 		YColumn: 7,
 		MinSampleRatio: 0.9,
+		DefaultMaxDistance: 20,
 	}
 
-	sheetVariants = []*sheetVariant{
-		&variantDW3, &variantA15X, &variantA15Xv1,
+	builtinVariants = []*sheetVariant{
+		&variantDW3Log, &variantDW3, &variantA15X, &variantA15Xv1,
 	}
 )
 
@@ -106,7 +144,7 @@ type sheetVariant struct {
 	HeaderChecks []sheetHeaderCheck
 
 	// column orientations
-	// These are not lettered, but numbered, so A=0
+	// These are not lettered, but numbered, so A=0. Optional ones are -1
 	SampleIndicatorColumn int
 	SysNameColumn int
 	ZSampleColumn int
@@ -117,6 +155,43 @@ type sheetVariant struct {
 	YColumn int
 	// 0..1, minimum ratio of samples filled in the survey sheet
 	MinSampleRatio float32
+	// used when the max distance cell is empty
+	DefaultMaxDistance float32
+	// the number of rows to use, 0 for all of the sheet
+	MaxRows int
+	// only sheets of this project match, empty for any
+	Project string
+	// the expected heights, nil for no expectations
+	Schedule *sampleSchedule
+}
+
+// rowLimit is the number of rows to use from the available ones
+func (sv *sheetVariant) rowLimit(rows int) int {
+	if sv.MaxRows > 0 {
+		return min(rows, sv.MaxRows)
+	}
+	return rows
+}
+
+// lastColumn is the highest column index the variant uses
+func (sv *sheetVariant) lastColumn() int {
+	last := 0
+	for _, col := range []int{sv.SampleIndicatorColumn, sv.SysNameColumn, sv.ZSampleColumn,
+		sv.SystemCountColumn, sv.MaxDistanceColumn, sv.XColumn, sv.ZColumn, sv.YColumn} {
+		last = max(last, col)
+	}
+	for _, check := range sv.HeaderChecks {
+		last = max(last, check.Column)
+	}
+	return last
+}
+
+func mustSampleSchedule(cfg *config.ScheduleConfig) *sampleSchedule {
+	ss, err := newSampleSchedule(cfg)
+	if err != nil {
+		panic(err)
+	}
+	return ss
 }
 
 // Column and Rows are on the 0-indexed result set, not cell designations
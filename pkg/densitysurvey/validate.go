@@ -0,0 +1,150 @@
+package densitysurvey
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"slices"
+
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/config"
+)
+
+// Flags attached to survey points by the validators, these are stored
+// in density.surveypoints.flags
+const (
+	FlagHeightDeviation = "height-deviation"
+	FlagColumnDrift = "column-drift"
+	FlagCoordinateMismatch = "coord-mismatch"
+	FlagOffSchedule = "off-schedule"
+	// survey level, stored in density.surveys.flags
+	FlagMissingHeights = "missing-heights"
+)
+
+// ValidationFlag is a single finding of a validator, for reporting
+type ValidationFlag struct {
+	SystemName string
+	Flag string
+	Detail string
+}
+
+func (vf ValidationFlag) String() string {
+	return fmt.Sprintf("%s: %s (%s)", vf.SystemName, vf.Flag, vf.Detail)
+}
+
+// ValidateGeometry checks the resolved coordinates of the survey points
+// against the sheet. The height has to match the ZSample, and all the points
+// are supposed to be in a vertical column, so the horizontal distance from
+// the column's axis is limited. Where the sheet has coordinates too, they
+// have to agree with the resolved ones.
+// Has to be called after the coordinate lookup. The flags are set on the
+// points as well as returned, flags of a previous run are replaced. The
+// coordinate mismatch is only checked again where the sheet coordinates are
+// known, the points loaded back from the database keep theirs otherwise.
+func (m *Survey) ValidateGeometry(cfg *config.ValidationConfig) []ValidationFlag {
+	flags := []ValidationFlag{}
+
+	for i := range m.SurveyPoints {
+		m.SurveyPoints[i].clearFlags(FlagHeightDeviation, FlagColumnDrift)
+		if m.SurveyPoints[i].SheetCoordinates != nil {
+			m.SurveyPoints[i].clearFlags(FlagCoordinateMismatch)
+		}
+	}
+
+	xs := make([]float64, 0, len(m.SurveyPoints))
+	ys := make([]float64, 0, len(m.SurveyPoints))
+	for _, dp := range m.SurveyPoints {
+		if !dp.Resolved {
+			continue
+		}
+		xs = append(xs, float64(dp.X))
+		ys = append(ys, float64(dp.Y))
+	}
+	if len(xs) == 0 {
+		return flags
+	}
+
+	// the axis of the column, median is used so a single
+	// misplaced point doesn't drag it away
+	ax := median(xs)
+	ay := median(ys)
+
+	for i, dp := range m.SurveyPoints {
+		if !dp.Resolved {
+			continue
+		}
+
+		dz := math.Abs(float64(dp.Z) - float64(dp.ZSample))
+		if cfg.MaxHeightDeviation > 0 && dz > float64(cfg.MaxHeightDeviation) {
+			m.SurveyPoints[i].addFlag(FlagHeightDeviation)
+			flags = append(flags, ValidationFlag{
+				SystemName: dp.SystemName,
+				Flag: FlagHeightDeviation,
+				Detail: fmt.Sprintf("z=%.1f zsample=%g deviation=%.1fly", dp.Z, dp.ZSample, dz),
+			})
+		}
+
+		if sc := dp.SheetCoordinates; sc != nil && dp.CoordSource != CoordSourceSheet {
+			diff := math.Sqrt(sq(dp.X-sc.X) + sq(dp.Y-sc.Y) + sq(dp.Z-sc.Z))
+			if cfg.MaxCoordinateMismatch > 0 && diff > float64(cfg.MaxCoordinateMismatch) {
+				m.SurveyPoints[i].addFlag(FlagCoordinateMismatch)
+				flags = append(flags, ValidationFlag{
+					SystemName: dp.SystemName,
+					Flag: FlagCoordinateMismatch,
+					Detail: fmt.Sprintf("sheet %.1f/%.1f/%.1f vs %s %.1f/%.1f/%.1f differ by %.1fly, using %s",
+						sc.X, sc.Y, sc.Z, dp.CoordSource, dp.X, dp.Y, dp.Z, diff, dp.CoordSource),
+				})
+			}
+		}
+
+		drift := math.Hypot(float64(dp.X)-ax, float64(dp.Y)-ay)
+		if cfg.MaxColumnDrift > 0 && drift > float64(cfg.MaxColumnDrift) {
+			m.SurveyPoints[i].addFlag(FlagColumnDrift)
+			flags = append(flags, ValidationFlag{
+				SystemName: dp.SystemName,
+				Flag: FlagColumnDrift,
+				Detail: fmt.Sprintf("%.1fly from the column axis at x=%.1f y=%.1f", drift, ax, ay),
+			})
+		}
+	}
+
+	return flags
+}
+
+func (dp *SurveyPoint) addFlag(flag string) {
+	for _, f := range dp.Flags {
+		if f == flag {
+			return
+		}
+	}
+	dp.Flags = append(dp.Flags, flag)
+}
+
+func (dp *SurveyPoint) clearFlags(flags ...string) {
+	dp.Flags = removeFlags(dp.Flags, flags...)
+}
+
+func removeFlags(from []string, flags ...string) []string {
+	kept := from[:0]
+	for _, f := range from {
+		if !slices.Contains(flags, f) {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+func sq(v float32) float64 {
+	return float64(v) * float64(v)
+}
+
+func median(values []float64) float64 {
+	s := make([]float64, len(values))
+	copy(s, values)
+	sort.Float64s(s)
+
+	n := len(s)
+	if n%2 == 1 {
+		return s[n/2]
+	}
+	return (s[n/2-1] + s[n/2]) / 2
+}
@@ -0,0 +1,162 @@
+package densitysurvey
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/config"
+)
+
+func resolvedPoint(name string, zsample, x, y, z float32) SurveyPoint {
+	return SurveyPoint{
+		SystemName: name,
+		ZSample: zsample,
+		X: x,
+		Y: y,
+		Z: z,
+		Resolved: true,
+		CoordSource: CoordSourceEDSM,
+	}
+}
+
+func TestValidateGeometry(t *testing.T) {
+	cfg := &config.ValidationConfig{
+		MaxHeightDeviation: 10,
+		MaxColumnDrift: 50,
+		MaxCoordinateMismatch: 5,
+	}
+
+	tests := []struct {
+		name string
+		points []SurveyPoint
+		// the flags expected per point
+		want [][]string
+		// the number of flags reported
+		reported int
+	}{
+		{
+			name: "clean column",
+			points: []SurveyPoint{
+				resolvedPoint("a", 0, 0, 0, 1),
+				resolvedPoint("b", 100, 5, -5, 98),
+				resolvedPoint("c", 200, -5, 5, 203),
+			},
+			want: [][]string{nil, nil, nil},
+			reported: 0,
+		},
+		{
+			name: "height deviation",
+			points: []SurveyPoint{
+				resolvedPoint("a", 0, 0, 0, 0),
+				resolvedPoint("b", 100, 0, 0, 150),
+			},
+			want: [][]string{nil, {FlagHeightDeviation}},
+			reported: 1,
+		},
+		{
+			name: "column drift, the median axis isn't dragged away",
+			points: []SurveyPoint{
+				resolvedPoint("a", 0, 0, 0, 0),
+				resolvedPoint("b", 100, 1, 1, 100),
+				resolvedPoint("c", 200, 500, 0, 200),
+			},
+			want: [][]string{nil, nil, {FlagColumnDrift}},
+			reported: 1,
+		},
+		{
+			name: "unresolved points are not checked",
+			points: []SurveyPoint{
+				resolvedPoint("a", 0, 0, 0, 0),
+				{SystemName: "b", ZSample: 100},
+			},
+			want: [][]string{nil, nil},
+			reported: 0,
+		},
+		{
+			name: "coordinate mismatch with the sheet",
+			points: []SurveyPoint{
+				func() SurveyPoint {
+					dp := resolvedPoint("a", 0, 0, 0, 0)
+					dp.SheetCoordinates = &Coordinates{X: 20, Y: 0, Z: 0, Source: CoordSourceSheet}
+					return dp
+				}(),
+				func() SurveyPoint {
+					dp := resolvedPoint("b", 100, 0, 0, 100)
+					dp.SheetCoordinates = &Coordinates{X: 1, Y: 1, Z: 100, Source: CoordSourceSheet}
+					return dp
+				}(),
+			},
+			want: [][]string{{FlagCoordinateMismatch}, nil},
+			reported: 1,
+		},
+		{
+			name: "the sheet's own coordinates are not cross-checked",
+			points: []SurveyPoint{
+				func() SurveyPoint {
+					dp := resolvedPoint("a", 0, 0, 0, 0)
+					dp.CoordSource = CoordSourceSheet
+					dp.SheetCoordinates = &Coordinates{X: 20, Y: 0, Z: 0, Source: CoordSourceSheet}
+					return dp
+				}(),
+			},
+			want: [][]string{nil},
+			reported: 0,
+		},
+		{
+			name: "stale flags are replaced",
+			points: []SurveyPoint{
+				func() SurveyPoint {
+					dp := resolvedPoint("a", 0, 0, 0, 0)
+					dp.Flags = []string{FlagHeightDeviation, FlagColumnDrift, FlagOffSchedule}
+					return dp
+				}(),
+			},
+			want: [][]string{{FlagOffSchedule}},
+			reported: 0,
+		},
+		{
+			name: "the mismatch is kept without the sheet coordinates",
+			points: []SurveyPoint{
+				func() SurveyPoint {
+					dp := resolvedPoint("a", 0, 0, 0, 0)
+					dp.Flags = []string{FlagCoordinateMismatch}
+					return dp
+				}(),
+			},
+			want: [][]string{{FlagCoordinateMismatch}},
+			reported: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := Survey{SurveyPoints: tt.points}
+			flags := m.ValidateGeometry(cfg)
+
+			for i, dp := range m.SurveyPoints {
+				got := slices.Clone(dp.Flags)
+				slices.Sort(got)
+				want := slices.Clone(tt.want[i])
+				slices.Sort(want)
+				if !slices.Equal(got, want) {
+					t.Errorf("%s: flags %v, want %v", dp.SystemName, got, want)
+				}
+			}
+			if len(flags) != tt.reported {
+				t.Errorf("%d flags reported, want %d: %v", len(flags), tt.reported, flags)
+			}
+		})
+	}
+}
+
+func TestValidateGeometryDisabled(t *testing.T) {
+	m := Survey{
+		SurveyPoints: []SurveyPoint{
+			resolvedPoint("a", 0, 0, 0, 0),
+			resolvedPoint("b", 100, 1000, 0, 500),
+		},
+	}
+	if flags := m.ValidateGeometry(&config.ValidationConfig{}); len(flags) != 0 {
+		t.Errorf("flags with the checks disabled: %v", flags)
+	}
+}
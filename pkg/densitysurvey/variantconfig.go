@@ -0,0 +1,215 @@
+package densitysurvey
+
+import (
+	"fmt"
+	"errors"
+	"strings"
+
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/config"
+)
+
+// Parser recognizes and parses the survey sheets. It tries the sheet
+// variants in order: the configured ones, then the built-ins unless
+// disabled, and the header-driven detection as the last resort.
+type Parser struct {
+	variants []*sheetVariant
+	detect config.DetectConfig
+	// the source's campaign of the sheets not naming one
+	project string
+	// the source's schedule, instead of the variants' ones
+	schedule *sampleSchedule
+	meta *metadataExtractor
+}
+
+// NewParser sets up a Parser from the configuration, a broken variant
+// definition or metadata pattern rejects the whole configuration
+func NewParser(cfg *config.VariantsConfig, metacfg *config.MetadataConfig) (*Parser, error) {
+	var reterr error = nil
+	variants := []*sheetVariant{}
+	names := map[string]bool{}
+
+	for i, def := range cfg.Definitions {
+		sv, err := newSheetVariant(&def)
+		if err != nil {
+			reterr = errors.Join(reterr, fmt.Errorf("sheet variant #%d (%s): %w", i+1, def.Name, err))
+			continue
+		}
+		if names[sv.Name] {
+			reterr = errors.Join(reterr, fmt.Errorf("sheet variant #%d: duplicate name %s", i+1, sv.Name))
+			continue
+		}
+		names[sv.Name] = true
+		variants = append(variants, sv)
+	}
+	if reterr != nil {
+		return nil, reterr
+	}
+
+	if cfg.Builtins {
+		variants = append(variants, builtinVariants...)
+	}
+	if len(variants) == 0 && !cfg.Detect.Enabled {
+		return nil, fmt.Errorf("No sheet variants defined and detection is disabled")
+	}
+	if cfg.Detect.Enabled {
+		if cfg.Detect.ScanRows < 1 {
+			return nil, fmt.Errorf("detect.scanrows has to be at least 1, got %d", cfg.Detect.ScanRows)
+		}
+		if cfg.Detect.MinSampleRatio <= 0 || cfg.Detect.MinSampleRatio > 1 {
+			return nil, fmt.Errorf("detect.minsampleratio has to be in (0,1], got %v", cfg.Detect.MinSampleRatio)
+		}
+		for column := range cfg.Detect.Synonyms {
+			if _, ok := headerSynonyms[column]; !ok {
+				return nil, fmt.Errorf("detect.synonyms: unknown column %s", column)
+			}
+		}
+	}
+
+	meta, err := newMetadataExtractor(metacfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Parser{
+		variants: variants,
+		detect: cfg.Detect,
+		meta: meta,
+	}, nil
+}
+
+// ForSource derives the Parser of a source: only its variants are tried,
+// its campaign is used for the sheets not naming one, and its schedule
+// replaces the variants' ones
+func (p *Parser) ForSource(cfg *config.SourceConfig) (*Parser, error) {
+	ret := *p
+	ret.project = strings.TrimSpace(cfg.Campaign)
+
+	if len(cfg.Variants) > 0 {
+		ret.variants = []*sheetVariant{}
+		ret.detect.Enabled = false
+		for _, name := range cfg.Variants {
+			if name == detectedVariant {
+				ret.detect.Enabled = p.detect.Enabled
+				continue
+			}
+			var found *sheetVariant
+			for _, sv := range p.variants {
+				if sv.Name == name {
+					found = sv
+					break
+				}
+			}
+			if found == nil {
+				return nil, fmt.Errorf("source %s: unknown sheet variant %s", cfg.Name, name)
+			}
+			ret.variants = append(ret.variants, found)
+		}
+	}
+
+	ss, err := newSampleSchedule(&cfg.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("source %s: %w", cfg.Name, err)
+	}
+	if ss != nil {
+		ret.schedule = ss
+	}
+
+	return &ret, nil
+}
+
+// newSheetVariant converts and validates a configured variant
+func newSheetVariant(def *config.SheetVariant) (*sheetVariant, error) {
+	var reterr error = nil
+
+	if def.Name == "" {
+		reterr = errors.Join(reterr, fmt.Errorf("name is missing"))
+	}
+
+	sv := &sheetVariant{
+		Name: def.Name,
+		HeaderRow: def.HeaderRow - 1,
+		MinSampleRatio: def.MinSampleRatio,
+		DefaultMaxDistance: def.DefaultMaxDistance,
+		MaxRows: def.MaxRows,
+		Project: def.Project,
+	}
+
+	if ss, err := newSampleSchedule(&def.Schedule); err != nil {
+		reterr = errors.Join(reterr, err)
+	} else {
+		sv.Schedule = ss
+	}
+
+	if def.HeaderRow < 1 {
+		reterr = errors.Join(reterr, fmt.Errorf("headerrow has to be at least 1, got %d", def.HeaderRow))
+	}
+	if sv.MinSampleRatio <= 0 || sv.MinSampleRatio > 1 {
+		reterr = errors.Join(reterr, fmt.Errorf("minsampleratio has to be in (0,1], got %v", def.MinSampleRatio))
+	}
+	if sv.DefaultMaxDistance == 0 {
+		sv.DefaultMaxDistance = 20
+	} else if sv.DefaultMaxDistance < 0 || sv.DefaultMaxDistance > 20 {
+		reterr = errors.Join(reterr, fmt.Errorf("defaultmaxdistance has to be in (0,20], got %v", def.DefaultMaxDistance))
+	}
+	if sv.MaxRows < 0 {
+		reterr = errors.Join(reterr, fmt.Errorf("maxrows can't be negative, got %d", def.MaxRows))
+	} else if sv.MaxRows > 0 && sv.MaxRows <= def.HeaderRow {
+		reterr = errors.Join(reterr, fmt.Errorf("maxrows %d doesn't leave room for samples after the header row %d",
+			def.MaxRows, def.HeaderRow))
+	}
+
+	columns := []struct {
+		name string
+		letters string
+		dst *int
+		required bool
+	}{
+		{"sysname", def.Columns.SysName, &sv.SysNameColumn, true},
+		{"zsample", def.Columns.ZSample, &sv.ZSampleColumn, true},
+		{"systemcount", def.Columns.SystemCount, &sv.SystemCountColumn, true},
+		{"sampleindicator", def.Columns.SampleIndicator, &sv.SampleIndicatorColumn, false},
+		{"maxdistance", def.Columns.MaxDistance, &sv.MaxDistanceColumn, false},
+		{"x", def.Columns.X, &sv.XColumn, false},
+		{"z", def.Columns.Z, &sv.ZColumn, false},
+		{"y", def.Columns.Y, &sv.YColumn, false},
+	}
+	for _, c := range columns {
+		*c.dst = -1
+		if c.letters == "" {
+			if c.required {
+				reterr = errors.Join(reterr, fmt.Errorf("column %s is missing", c.name))
+			}
+			continue
+		}
+		idx, err := columnIndex(c.letters)
+		if err != nil {
+			reterr = errors.Join(reterr, fmt.Errorf("column %s: %w", c.name, err))
+			continue
+		}
+		*c.dst = idx
+	}
+
+	if len(def.HeaderChecks) == 0 {
+		reterr = errors.Join(reterr, fmt.Errorf("at least one header check is needed"))
+	}
+	for _, hc := range def.HeaderChecks {
+		row, col, err := parseCell(hc.Cell)
+		if err != nil {
+			reterr = errors.Join(reterr, fmt.Errorf("header check: %w", err))
+			continue
+		}
+		if sv.MaxRows > 0 && row >= sv.MaxRows {
+			reterr = errors.Join(reterr, fmt.Errorf("header check %s is beyond maxrows %d", hc.Cell, sv.MaxRows))
+		}
+		sv.HeaderChecks = append(sv.HeaderChecks, sheetHeaderCheck{
+			Column: col,
+			Row: row,
+			Value: hc.Value,
+		})
+	}
+
+	if reterr != nil {
+		return nil, reterr
+	}
+	return sv, nil
+}
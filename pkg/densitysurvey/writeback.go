@@ -0,0 +1,87 @@
+package densitysurvey
+
+import (
+	"math"
+	"time"
+	"errors"
+	"context"
+	"strings"
+)
+
+const (
+	// A1 of the results sheet, these sheets are not parsed as surveys
+	resultsMarker = "Computed by dw-stellar-density-analyzer"
+)
+
+var (
+	errResultsSheet = errors.New("results sheet")
+)
+
+// WriteResults writes what was computed from the surveys into a dedicated
+// sheet of the survey spreadsheet, replacing the sheet's previous content.
+// The sheet is created when missing.
+func (ds *DensitySpreadsheet) WriteResults(ctx context.Context, tab string, surveys []Survey) error {
+	if ds.spreadsheet.SheetByTitle(tab) == nil {
+		if _, err := ds.spreadsheet.AddSheet(ctx, tab); err != nil {
+			return err
+		}
+	} else if err := ds.spreadsheet.ClearSheet(ctx, tab); err != nil {
+		return err
+	}
+
+	values := [][]interface{}{
+		{resultsMarker, time.Now().UTC().Format(time.RFC3339)},
+		{"Do not edit, this sheet is overwritten on every ingest"},
+	}
+	for _, m := range surveys {
+		values = append(values, resultRows(&m)...)
+	}
+
+	return ds.spreadsheet.WriteRange(ctx, tab, "A1", values)
+}
+
+// resultRows is a survey's block in the results sheet
+func resultRows(m *Survey) [][]interface{} {
+	rows := [][]interface{}{
+		{},
+		{"Survey", m.Name, "CMDR", m.CMDR, "Project", m.Project, "Variant", m.Variant},
+	}
+
+	fit, ok := m.FitProfile()
+	if ok {
+		rows = append(rows, []interface{}{"Fit rho0*exp(-|z|/h)", "points", fit.Points,
+			"rho0", fit.Rho0, "h (ly)", nanEmpty(fit.ScaleHeight), "r2", fit.R2})
+	} else {
+		rows = append(rows, []interface{}{"Fit rho0*exp(-|z|/h)", "points", fit.Points,
+			"not enough resolved points"})
+	}
+	if len(m.Flags) > 0 {
+		rows = append(rows, []interface{}{"Flags", strings.Join(m.Flags, ", ")})
+	}
+
+	// coordinates in the game's orientation, like in the survey sheets
+	rows = append(rows, []interface{}{"System", "Z sample", "Count", "Max distance",
+		"rho", "rho low", "rho high", "X", "Y", "Z", "Coordinate source", "Flags"})
+	for _, dp := range m.SurveyPoints {
+		rho, lower, upper := dp.Density()
+		row := []interface{}{dp.SystemName, dp.ZSample, dp.Count, dp.MaxDistance,
+			rho, lower, upper}
+		if dp.Resolved {
+			row = append(row, dp.X, dp.Z, dp.Y, dp.CoordSource)
+		} else {
+			row = append(row, "", "", "", "unresolved")
+		}
+		row = append(row, strings.Join(dp.Flags, ", "))
+		rows = append(rows, row)
+	}
+
+	return rows
+}
+
+// NaN can't be sent to the API
+func nanEmpty(v float64) interface{} {
+	if math.IsNaN(v) {
+		return ""
+	}
+	return v
+}
@@ -1,6 +1,7 @@
 package edsm
 
 import (
+	"context"
 	"net/url"
 	"net/http"
 	"encoding/json"
@@ -13,16 +14,23 @@ type EDSM struct {
 }
 
 func New() *EDSM {
+	return NewWithClient(&http.Client{})
+}
+
+// NewWithClient uses the given HTTP client for the requests
+func NewWithClient(client *http.Client) *EDSM {
 	return &EDSM{
-		client: &http.Client{},
+		client: client,
 	}
 }
 
-func (e *EDSM) newRequest(method string, endpoint string) (req *http.Request, err error) {
-	req, err = http.NewRequest(method, urlBase, nil)
+func (e *EDSM) newRequest(ctx context.Context, method string, endpoint string) (req *http.Request, err error) {
 	var (
 		base *url.URL
 	)
+	if req, err = http.NewRequestWithContext(ctx, method, urlBase, nil); err != nil {
+		return
+	}
 	if base, err = url.Parse(urlBase); err != nil {
 		return
 	}
@@ -38,8 +46,7 @@ func (e *EDSM) newRequest(method string, endpoint string) (req *http.Request, er
 
 func (e *EDSM) call(req *http.Request, v any) (resp *http.Response, err error) {
 
-	c := http.Client{}
-	if resp, err = c.Do(req); err != nil {
+	if resp, err = e.client.Do(req); err != nil {
 		return
 	}
 	defer resp.Body.Close()
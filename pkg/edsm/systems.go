@@ -2,6 +2,7 @@ package edsm
 
 import (
 	"fmt"
+	"context"
 	"errors"
 )
 
@@ -18,8 +19,8 @@ type Coordinates struct {
 }
 
 
-func (e *EDSM) Systems(names []string) ([]SystemData, error) {
-	req, err := e.newRequest("GET", "/api-v1/systems")
+func (e *EDSM) Systems(ctx context.Context, names []string) ([]SystemData, error) {
+	req, err := e.newRequest(ctx, "GET", "/api-v1/systems")
 	if err != nil {
 		return []SystemData{}, errors.Join(err, fmt.Errorf("Unable to query systems %v", names))
 	}
@@ -0,0 +1,235 @@
+package google
+
+import (
+	"os"
+	"fmt"
+	"net"
+	"sync"
+	"errors"
+	"context"
+	"net/http"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+
+	"golang.org/x/oauth2"
+	googleoauth "golang.org/x/oauth2/google"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/sheets/v4"
+	htransport "google.golang.org/api/transport/http"
+
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/config"
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/cassette"
+)
+
+// all the scopes used, requested at once for the user's token
+var oauthScopes = []string{
+	sheets.SpreadsheetsScope,
+	drive.DriveMetadataReadonlyScope,
+}
+
+const (
+	AuthServiceAccount = "serviceaccount"
+	AuthADC = "adc"
+	AuthOAuth = "oauth"
+	AuthAPIKey = "apikey"
+	AuthImpersonate = "impersonate"
+)
+
+// clientOptions are the API client options authenticating as configured,
+// with the scopes requested
+func clientOptions(ctx context.Context, cfg *config.GoogleConfig, scopes ...string) ([]option.ClientOption, error) {
+	switch cfg.Auth {
+	case AuthServiceAccount, "":
+		return []option.ClientOption{
+			option.WithCredentialsFile(cfg.Credentials),
+			option.WithScopes(scopes...),
+		}, nil
+	case AuthADC:
+		creds, err := googleoauth.FindDefaultCredentials(ctx, scopes...)
+		if err != nil {
+			return nil, errors.Join(err, fmt.Errorf("No application default credentials"))
+		}
+		return []option.ClientOption{option.WithCredentials(creds)}, nil
+	case AuthOAuth:
+		// the cached token is shared by the services
+		ts, err := oauthTokenSource(ctx, &cfg.OAuth, oauthScopes)
+		if err != nil {
+			return nil, err
+		}
+		return []option.ClientOption{option.WithTokenSource(ts)}, nil
+	case AuthAPIKey:
+		if cfg.APIKey == "" {
+			return nil, fmt.Errorf("google.apikey is required for auth %s", cfg.Auth)
+		}
+		return []option.ClientOption{option.WithAPIKey(cfg.APIKey)}, nil
+	case AuthImpersonate:
+		if cfg.Impersonate.Target == "" {
+			return nil, fmt.Errorf("google.impersonate.target is required for auth %s", cfg.Auth)
+		}
+		// the caller is either the credentials file or the ADC
+		base := []option.ClientOption{}
+		if _, err := os.Stat(cfg.Credentials); err == nil {
+			base = append(base, option.WithCredentialsFile(cfg.Credentials))
+		}
+		ts, err := impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+			TargetPrincipal: cfg.Impersonate.Target,
+			Delegates: cfg.Impersonate.Delegates,
+			Scopes: scopes,
+		}, base...)
+		if err != nil {
+			return nil, errors.Join(err, fmt.Errorf("Unable to impersonate %s", cfg.Impersonate.Target))
+		}
+		return []option.ClientOption{option.WithTokenSource(ts)}, nil
+	}
+
+	return nil, fmt.Errorf("Unknown google.auth %q", cfg.Auth)
+}
+
+// recordedOptions are the client options with the traffic going through the
+// cassette. Replaying needs no credentials.
+func recordedOptions(ctx context.Context, cfg *config.GoogleConfig, c *cassette.Cassette,
+	name string, scopes ...string) ([]option.ClientOption, error) {
+
+	if c.Replaying() {
+		return []option.ClientOption{
+			option.WithHTTPClient(c.Client(name, nil)),
+		}, nil
+	}
+
+	opts, err := clientOptions(ctx, cfg, scopes...)
+	if err != nil || c == nil {
+		return opts, err
+	}
+
+	client, _, err := htransport.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return []option.ClientOption{
+		option.WithHTTPClient(c.Client(name, client)),
+	}, nil
+}
+
+// oauthTokenSource is the user's token from the cache, or from the browser
+// flow when there is none yet. Refreshed tokens are written to the cache.
+func oauthTokenSource(ctx context.Context, cfg *config.OAuthConfig, scopes []string) (oauth2.TokenSource, error) {
+	secrets, err := os.ReadFile(cfg.ClientSecrets)
+	if err != nil {
+		return nil, errors.Join(err, fmt.Errorf("Unable to read google.oauth.clientsecrets"))
+	}
+	oc, err := googleoauth.ConfigFromJSON(secrets, scopes...)
+	if err != nil {
+		return nil, errors.Join(err, fmt.Errorf("Invalid OAuth client %s", cfg.ClientSecrets))
+	}
+
+	tok, err := readToken(cfg.TokenCache)
+	if err != nil {
+		if tok, err = authorize(ctx, oc); err != nil {
+			return nil, err
+		}
+		if err = writeToken(cfg.TokenCache, tok); err != nil {
+			return nil, err
+		}
+	}
+
+	return &cachedTokenSource{
+		path: cfg.TokenCache,
+		src: oc.TokenSource(ctx, tok),
+		last: tok.AccessToken,
+	}, nil
+}
+
+// authorize runs the OAuth flow in the user's browser, the code is received
+// on a loopback redirect
+func authorize(ctx context.Context, oc *oauth2.Config) (*oauth2.Token, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+	oc.RedirectURL = fmt.Sprintf("http://%s/", l.Addr())
+
+	buf := make([]byte, 16)
+	if _, err = rand.Read(buf); err != nil {
+		return nil, err
+	}
+	state := hex.EncodeToString(buf)
+
+	codes := make(chan string, 1)
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query()
+			if q.Get("state") != state || q.Get("code") == "" {
+				http.Error(w, "invalid authorization response", http.StatusBadRequest)
+				return
+			}
+			fmt.Fprintf(w, "Authorized, you can close this window.\n")
+			select {
+			case codes <- q.Get("code"):
+			default:
+			}
+		}),
+	}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	fmt.Printf("Open this link in your browser to authorize the access to the sheets:\n%s\n",
+		oc.AuthCodeURL(state, oauth2.AccessTypeOffline))
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case code := <-codes:
+		return oc.Exchange(ctx, code)
+	}
+}
+
+func readToken(path string) (*oauth2.Token, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tok := &oauth2.Token{}
+	if err = json.Unmarshal(data, tok); err != nil {
+		return nil, err
+	}
+	return tok, nil
+}
+
+func writeToken(path string, tok *oauth2.Token) error {
+	data, err := json.Marshal(tok)
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(path, data, 0600); err != nil {
+		return errors.Join(err, fmt.Errorf("Unable to write the token cache %s", path))
+	}
+	return nil
+}
+
+// cachedTokenSource writes the token to the cache whenever it's refreshed
+type cachedTokenSource struct {
+	path string
+	src oauth2.TokenSource
+	mtx sync.Mutex
+	last string
+}
+
+func (c *cachedTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := c.src.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if tok.AccessToken != c.last {
+		c.last = tok.AccessToken
+		// a failure only means another browser flow on the next run
+		writeToken(c.path, tok)
+	}
+	return tok, nil
+}
@@ -0,0 +1,88 @@
+package google
+
+import (
+	"errors"
+	"fmt"
+	"context"
+	"strconv"
+	"log/slog"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/config"
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/cassette"
+)
+
+const (
+	MimeFolder = "application/vnd.google-apps.folder"
+	MimeSpreadsheet = "application/vnd.google-apps.spreadsheet"
+)
+
+type GDriveService struct {
+	DriveService *drive.Service
+	limiter *Limiter
+}
+
+// NewDrive creates the Drive client, with the same authentication and
+// cassette as NewSheets
+func NewDrive(ctx context.Context, cfg *config.GoogleConfig, c *cassette.Cassette,
+	logger *slog.Logger) (*GDriveService, error) {
+	var err error
+
+	gd := &GDriveService{
+		limiter: serviceLimiter(&cfg.RateLimit, c, logger),
+	}
+
+	opts, err := recordedOptions(ctx, cfg, c, "drive", drive.DriveMetadataReadonlyScope)
+	if err != nil {
+		return nil, err
+	}
+
+	if gd.DriveService, err = drive.NewService(ctx, opts...); err != nil {
+		return nil, err
+	}
+
+	return gd, nil
+}
+
+// Revision is the version of the file, increasing with every change
+func (d *GDriveService) Revision(ctx context.Context, fileid string) (string, error) {
+	f := func() (*drive.File, error) {
+		return d.DriveService.Files.Get(fileid).Fields("version").SupportsAllDrives(true).
+			Context(ctx).Do()
+	}
+	file, err := Call(ctx, d.limiter, f)
+	if err != nil {
+		return "", errors.Join(err, fmt.Errorf("Revision(%s)", fileid))
+	}
+	return strconv.FormatInt(file.Version, 10), nil
+}
+
+// ListFolder lists the files and folders directly in the folder, shared
+// drives included
+func (d *GDriveService) ListFolder(ctx context.Context, folderid string) ([]*drive.File, error) {
+	ret := []*drive.File{}
+	q := fmt.Sprintf("'%s' in parents and trashed = false", folderid)
+
+	pagetoken := ""
+	for {
+		f := func() (*drive.FileList, error) {
+			call := d.DriveService.Files.List().Q(q).PageSize(1000).
+				Fields("nextPageToken,files(id,name,mimeType,owners(emailAddress,displayName))").
+				SupportsAllDrives(true).IncludeItemsFromAllDrives(true).Context(ctx)
+			if pagetoken != "" {
+				call = call.PageToken(pagetoken)
+			}
+			return call.Do()
+		}
+		list, err := Call(ctx, d.limiter, f)
+		if err != nil {
+			return ret, errors.Join(err, fmt.Errorf("ListFolder(%s)", folderid))
+		}
+		ret = append(ret, list.Files...)
+		if pagetoken = list.NextPageToken; pagetoken == "" {
+			break
+		}
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,176 @@
+package google
+
+import (
+	"io"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+	"errors"
+	"context"
+	"strconv"
+	"syscall"
+	"net/http"
+	"log/slog"
+	"math/rand/v2"
+
+	"google.golang.org/api/googleapi"
+
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/config"
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/cassette"
+)
+
+// Limiter paces the API calls to the per-minute quota, and retries the
+// failed ones with exponential backoff. It's shared by all the calls of
+// a service.
+type Limiter struct {
+	cfg config.RateLimitConfig
+	logger *slog.Logger
+	mtx sync.Mutex
+	// the earliest time the next call can start
+	next time.Time
+}
+
+// NewLimiter creates a Limiter, the logger defaults to slog.Default
+func NewLimiter(cfg *config.RateLimitConfig, logger *slog.Logger) *Limiter {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Limiter{
+		cfg: *cfg,
+		logger: logger,
+	}
+}
+
+// serviceLimiter creates the Limiter of a service, replaying a cassette
+// the calls are neither paced nor retried
+func serviceLimiter(cfg *config.RateLimitConfig, c *cassette.Cassette, logger *slog.Logger) *Limiter {
+	if c.Replaying() {
+		return NewLimiter(&config.RateLimitConfig{}, logger)
+	}
+	return NewLimiter(cfg, logger)
+}
+
+// Call runs f through the limiter. Rate limited (429), unavailable (5xx)
+// and dropped connections are retried up to the configured number of
+// times, honouring Retry-After.
+func Call[T any](ctx context.Context, l *Limiter, f func() (T, error)) (T, error) {
+	var (
+		ret T
+		err error
+	)
+
+	for attempt := 0; ; attempt += 1 {
+		if err = l.pace(ctx); err != nil {
+			return ret, err
+		}
+
+		if ret, err = f(); err == nil || !retryable(err) || ctx.Err() != nil {
+			return ret, err
+		}
+		if attempt >= l.cfg.MaxRetries {
+			return ret, errors.Join(err, fmt.Errorf("Giving up after %d retries", attempt))
+		}
+
+		wait := l.backoff(attempt)
+		if ra := retryAfter(err); ra > wait {
+			wait = ra
+		}
+		l.logger.Warn("google api call failed, waiting", "wait", wait.Round(time.Millisecond),
+			"attempt", attempt+1, "maxretries", l.cfg.MaxRetries, "error", err)
+		if err = sleep(ctx, wait); err != nil {
+			return ret, err
+		}
+	}
+}
+
+// pace waits for the next free slot of the quota
+func (l *Limiter) pace(ctx context.Context) error {
+	if l.cfg.RequestsPerMinute <= 0 {
+		return ctx.Err()
+	}
+	interval := time.Minute / time.Duration(l.cfg.RequestsPerMinute)
+
+	l.mtx.Lock()
+	now := time.Now()
+	slot := l.next
+	if slot.Before(now) {
+		slot = now
+	}
+	l.next = slot.Add(interval)
+	l.mtx.Unlock()
+
+	if wait := slot.Sub(now); wait > 0 {
+		if wait >= time.Second {
+			l.logger.Debug("pacing google api calls", "wait", wait.Round(time.Millisecond))
+		}
+		return sleep(ctx, wait)
+	}
+	return ctx.Err()
+}
+
+// backoff is the exponential delay of the retry, with jitter between
+// the half and the whole of it
+func (l *Limiter) backoff(attempt int) time.Duration {
+	d := l.cfg.BaseDelay
+	for i := 0; i < attempt && d < l.cfg.MaxDelay; i += 1 {
+		d *= 2
+	}
+	d = min(d, l.cfg.MaxDelay)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// retryable tells whether the call may succeed when repeated
+func retryable(err error) bool {
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		switch gerr.Code {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
+	}
+	var nerr net.Error
+	return errors.As(err, &nerr) && nerr.Timeout()
+}
+
+// retryAfter is the delay asked by the server, 0 if none
+func retryAfter(err error) time.Duration {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) || gerr.Header == nil {
+		return 0
+	}
+	v := gerr.Header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
@@ -0,0 +1,194 @@
+package google
+
+import (
+	"io"
+	"fmt"
+	"time"
+	"errors"
+	"context"
+	"syscall"
+	"testing"
+	"net/http"
+
+	"google.golang.org/api/googleapi"
+
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/config"
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/cassette"
+)
+
+// timeoutError is a net.Error timing out
+type timeoutError struct{}
+
+func (timeoutError) Error() string { return "i/o timeout" }
+func (timeoutError) Timeout() bool { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func apiError(code int, retryafter string) error {
+	gerr := &googleapi.Error{Code: code}
+	if retryafter != "" {
+		gerr.Header = http.Header{"Retry-After": {retryafter}}
+	}
+	return fmt.Errorf("call failed: %w", gerr)
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err error
+		want bool
+	}{
+		{"rate limited", apiError(http.StatusTooManyRequests, ""), true},
+		{"unavailable", apiError(http.StatusServiceUnavailable, ""), true},
+		{"internal error", apiError(http.StatusInternalServerError, ""), true},
+		{"not found", apiError(http.StatusNotFound, ""), false},
+		{"forbidden", apiError(http.StatusForbidden, ""), false},
+		{"connection reset", fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{"unexpected eof", io.ErrUnexpectedEOF, true},
+		{"timeout", fmt.Errorf("get: %w", timeoutError{}), true},
+		{"canceled", context.Canceled, false},
+		{"deadline", fmt.Errorf("get: %w", context.DeadlineExceeded), false},
+		{"other", errors.New("bad request"), false},
+	}
+	for _, tt := range tests {
+		if got := retryable(tt.err); got != tt.want {
+			t.Errorf("%s: retryable %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name string
+		err error
+		min, max time.Duration
+	}{
+		{"seconds", apiError(http.StatusTooManyRequests, "30"), 30 * time.Second, 30 * time.Second},
+		{"date", apiError(http.StatusTooManyRequests, time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)),
+			58 * time.Second, time.Minute},
+		{"past date", apiError(http.StatusTooManyRequests, "Mon, 02 Jan 2006 15:04:05 GMT"), 0, 0},
+		{"garbage", apiError(http.StatusTooManyRequests, "soon"), 0, 0},
+		{"negative", apiError(http.StatusTooManyRequests, "-5"), 0, 0},
+		{"no header", apiError(http.StatusTooManyRequests, ""), 0, 0},
+		{"not an api error", errors.New("failed"), 0, 0},
+	}
+	for _, tt := range tests {
+		if got := retryAfter(tt.err); got < tt.min || got > tt.max {
+			t.Errorf("%s: retryAfter %v, want %v..%v", tt.name, got, tt.min, tt.max)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	l := NewLimiter(&config.RateLimitConfig{
+		BaseDelay: 100 * time.Millisecond,
+		MaxDelay: time.Second,
+	}, nil)
+
+	tests := []struct {
+		attempt int
+		// the delay before the jitter
+		full time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 200 * time.Millisecond},
+		{3, 800 * time.Millisecond},
+		{4, time.Second},
+		{20, time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 50; i += 1 {
+			if got := l.backoff(tt.attempt); got < tt.full/2 || got > tt.full {
+				t.Errorf("backoff(%d) = %v, want %v..%v", tt.attempt, got, tt.full/2, tt.full)
+				break
+			}
+		}
+	}
+
+	if got := NewLimiter(&config.RateLimitConfig{}, nil).backoff(3); got != 0 {
+		t.Errorf("backoff without delays = %v", got)
+	}
+}
+
+func TestCall(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		MaxRetries: 2,
+		BaseDelay: time.Millisecond,
+		MaxDelay: time.Millisecond,
+	}
+
+	tests := []struct {
+		name string
+		errs []error
+		calls int
+		fails bool
+	}{
+		{"success", nil, 1, false},
+		{"retried", []error{apiError(http.StatusServiceUnavailable, ""), io.ErrUnexpectedEOF}, 3, false},
+		{"not retryable", []error{apiError(http.StatusNotFound, "")}, 1, true},
+		{"gives up", []error{apiError(http.StatusTooManyRequests, ""), apiError(http.StatusTooManyRequests, ""),
+			apiError(http.StatusTooManyRequests, "")}, 3, true},
+	}
+	for _, tt := range tests {
+		calls := 0
+		got, err := Call(context.Background(), NewLimiter(cfg, nil), func() (int, error) {
+			calls += 1
+			if calls <= len(tt.errs) {
+				return 0, tt.errs[calls-1]
+			}
+			return 42, nil
+		})
+		if calls != tt.calls || (err != nil) != tt.fails || (!tt.fails && got != 42) {
+			t.Errorf("%s: %d calls, %v, %v; want %d calls, failing %v", tt.name, calls, got, err,
+				tt.calls, tt.fails)
+		}
+	}
+}
+
+func TestCallCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	l := NewLimiter(&config.RateLimitConfig{
+		MaxRetries: 5,
+		BaseDelay: time.Hour,
+		MaxDelay: time.Hour,
+	}, nil)
+
+	calls := 0
+	_, err := Call(ctx, l, func() (int, error) {
+		calls += 1
+		cancel()
+		return 0, apiError(http.StatusServiceUnavailable, "")
+	})
+	if calls != 1 || err == nil {
+		t.Errorf("%d calls, %v after canceling", calls, err)
+	}
+}
+
+func TestPace(t *testing.T) {
+	l := NewLimiter(&config.RateLimitConfig{RequestsPerMinute: 600}, nil)
+	start := time.Now()
+	for i := 0; i < 3; i += 1 {
+		if err := l.pace(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the first call goes immediately, the rest 100ms apart
+	if d := time.Since(start); d < 200*time.Millisecond {
+		t.Errorf("3 calls in %v at 600/min", d)
+	}
+}
+
+func TestServiceLimiter(t *testing.T) {
+	cfg := &config.RateLimitConfig{RequestsPerMinute: 60, MaxRetries: 5}
+
+	if l := serviceLimiter(cfg, nil, nil); l.cfg != *cfg {
+		t.Errorf("limiter without a cassette: %+v", l.cfg)
+	}
+
+	c, err := cassette.New(&config.CassetteConfig{Mode: cassette.ModeReplay, Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := serviceLimiter(cfg, c, nil); l.cfg.RequestsPerMinute != 0 || l.cfg.MaxRetries != 0 {
+		t.Errorf("limiter replaying: %+v", l.cfg)
+	}
+}
@@ -4,47 +4,67 @@ import (
 	"fmt"
 	"time"
 	"errors"
+	"strings"
 	"context"
+	"log/slog"
 	"google.golang.org/api/sheets/v4"
+	"google.golang.org/api/googleapi"
 	"golang.org/x/oauth2"
-	"google.golang.org/api/option"
+
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/config"
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/cassette"
 )
 
 type GSpreadsheetsService struct {
 	Token *oauth2.Token
 	SheetsService *sheets.Service
+	limiter *Limiter
 }
 
 type GSpreadsheet struct {
 	Sheet *sheets.Spreadsheet
 	SheetsService *sheets.Service
 	ID string
+	limiter *Limiter
 }
 
-func NewSheets(credfile string) (*GSpreadsheetsService, error) {
+// NewSheets creates the Sheets client, authenticating as configured. The
+// traffic goes through the cassette, if there's one. The calls are paced
+// and retried by a limiter reporting to the logger, unless replaying.
+func NewSheets(ctx context.Context, cfg *config.GoogleConfig, c *cassette.Cassette,
+	logger *slog.Logger) (*GSpreadsheetsService, error) {
 	var err error
-	ctx := context.Background()
 
-	gs := &GSpreadsheetsService{}
+	gs := &GSpreadsheetsService{
+		limiter: serviceLimiter(&cfg.RateLimit, c, logger),
+	}
 
-	creds := option.WithCredentialsFile(credfile)
-	scopes := option.WithScopes(sheets.SpreadsheetsScope)
+	opts, err := recordedOptions(ctx, cfg, c, "sheets", sheets.SpreadsheetsScope)
+	if err != nil {
+		return nil, err
+	}
 
-	if gs.SheetsService, err = sheets.NewService(ctx, creds, scopes); err != nil {
+	if gs.SheetsService, err = sheets.NewService(ctx, opts...); err != nil {
 		return nil, err
 	}
 
 	return gs, nil
 }
 
-func (s *GSpreadsheetsService) Sheet(id string) (*GSpreadsheet, error) {
+func (s *GSpreadsheetsService) Sheet(ctx context.Context, id string) (*GSpreadsheet, error) {
 	var err error
 	sheet := &GSpreadsheet{
 		ID: id,
 		SheetsService: s.SheetsService,
+		limiter: s.limiter,
 	}
 
-	sheet.Sheet, err = s.SheetsService.Spreadsheets.Get(id).Do()
+	// only the metadata, the values are read separately
+	f := func() (*sheets.Spreadsheet, error) {
+		return s.SheetsService.Spreadsheets.Get(id).
+			Fields("spreadsheetId,properties(title,timeZone),sheets(properties)").Context(ctx).Do()
+	}
+	sheet.Sheet, err = Call(ctx, s.limiter, f)
 	if err != nil {
 		return nil, err
 	}
@@ -56,15 +76,141 @@ func (s *GSpreadsheet) GetSheets() []*sheets.Sheet {
 	return s.Sheet.Sheets
 }
 
-func (s *GSpreadsheet) ReadRange(sheet string, start string, end string) (ret *sheets.ValueRange, err error) {
-	rangestr := fmt.Sprintf("%s!%s:%s", sheet, start, end)
-	f := func() (*sheets.ValueRange, error) {
-		return s.SheetsService.Spreadsheets.Values.Get(s.ID, rangestr).Do()
+// Location is the time zone of the spreadsheet, UTC if it's unknown
+func (s *GSpreadsheet) Location() *time.Location {
+	if s.Sheet.Properties == nil || s.Sheet.Properties.TimeZone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(s.Sheet.Properties.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// BatchReadRanges reads multiple A1 ranges in a single call, the results
+// are in the order of the ranges
+func (s *GSpreadsheet) BatchReadRanges(ctx context.Context, ranges []string) ([]*sheets.ValueRange, error) {
+	f := func() (*sheets.BatchGetValuesResponse, error) {
+		return s.SheetsService.Spreadsheets.Values.BatchGet(s.ID).Ranges(ranges...).
+			ValueRenderOption("UNFORMATTED_VALUE").Context(ctx).Do()
+	}
+	ret, err := Call(ctx, s.limiter, f)
+	if err != nil {
+		return nil, errors.Join(err, fmt.Errorf("BatchReadRanges(%s, %d ranges)", s.ID, len(ranges)))
+	}
+	if len(ret.ValueRanges) != len(ranges) {
+		return nil, fmt.Errorf("BatchReadRanges(%s): %d ranges returned for %d", s.ID,
+			len(ret.ValueRanges), len(ranges))
+	}
+	return ret.ValueRanges, nil
+}
+
+// SheetByTitle returns the sheet (tab) with the given title, nil if there's none
+func (s *GSpreadsheet) SheetByTitle(title string) *sheets.Sheet {
+	for _, sheet := range s.Sheet.Sheets {
+		if sheet.Properties.Title == title {
+			return sheet
+		}
+	}
+	return nil
+}
+
+// BatchUpdate applies the requests to the spreadsheet in one call
+func (s *GSpreadsheet) BatchUpdate(ctx context.Context, reqs []*sheets.Request) (*sheets.BatchUpdateSpreadsheetResponse, error) {
+	f := func() (*sheets.BatchUpdateSpreadsheetResponse, error) {
+		return s.SheetsService.Spreadsheets.BatchUpdate(s.ID, &sheets.BatchUpdateSpreadsheetRequest{
+			Requests: reqs,
+		}).Context(ctx).Do()
+	}
+	ret, err := Call(ctx, s.limiter, f)
+	if err != nil {
+		err = errors.Join(err, fmt.Errorf("BatchUpdate(%s)", s.ID))
+	}
+	return ret, err
+}
+
+// AddSheet creates a new sheet (tab) with the given title
+func (s *GSpreadsheet) AddSheet(ctx context.Context, title string) (*sheets.Sheet, error) {
+	resp, err := s.BatchUpdate(ctx, []*sheets.Request{
+		&sheets.Request{
+			AddSheet: &sheets.AddSheetRequest{
+				Properties: &sheets.SheetProperties{
+					Title: title,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sheet := &sheets.Sheet{
+		Properties: resp.Replies[0].AddSheet.Properties,
+	}
+	s.Sheet.Sheets = append(s.Sheet.Sheets, sheet)
+	return sheet, nil
+}
+
+// ClearSheet clears the values of a whole sheet (tab)
+func (s *GSpreadsheet) ClearSheet(ctx context.Context, sheet string) error {
+	f := func() (*sheets.ClearValuesResponse, error) {
+		return s.SheetsService.Spreadsheets.Values.Clear(s.ID, QuoteSheet(sheet), &sheets.ClearValuesRequest{}).Context(ctx).Do()
+	}
+	_, err := Call(ctx, s.limiter, f)
+	if err != nil {
+		err = errors.Join(err, fmt.Errorf("ClearSheet(%s)", sheet))
+	}
+	return err
+}
+
+// WriteRange writes the values to the sheet starting at the start cell,
+// the values are taken as they are, not parsed as user input
+func (s *GSpreadsheet) WriteRange(ctx context.Context, sheet string, start string, values [][]interface{}) error {
+	rangestr := fmt.Sprintf("%s!%s", QuoteSheet(sheet), start)
+	f := func() (*sheets.UpdateValuesResponse, error) {
+		return s.SheetsService.Spreadsheets.Values.Update(s.ID, rangestr, &sheets.ValueRange{
+			Values: values,
+		}).ValueInputOption("RAW").Context(ctx).Do()
+	}
+	_, err := Call(ctx, s.limiter, f)
+	if err != nil {
+		err = errors.Join(err, fmt.Errorf("WriteRange(%s)", rangestr))
+	}
+	return err
+}
+
+// BatchWriteRanges writes multiple ranges in a single call, the values are
+// taken as they are, not parsed as user input
+func (s *GSpreadsheet) BatchWriteRanges(ctx context.Context, data []*sheets.ValueRange) error {
+	f := func() (*sheets.BatchUpdateValuesResponse, error) {
+		return s.SheetsService.Spreadsheets.Values.BatchUpdate(s.ID, &sheets.BatchUpdateValuesRequest{
+			Data: data,
+			ValueInputOption: "RAW",
+		}).Context(ctx).Do()
+	}
+	_, err := Call(ctx, s.limiter, f)
+	if err != nil {
+		err = errors.Join(err, fmt.Errorf("BatchWriteRanges(%s, %d ranges)", s.ID, len(data)))
+	}
+	return err
+}
+
+// QuoteSheet quotes a sheet title for A1 notation ranges
+func QuoteSheet(title string) string {
+	return "'" + strings.ReplaceAll(title, "'", "''") + "'"
+}
+
+// GridDataRanges loads the grid data of the A1 ranges, limited to the given
+// fields
+func (s *GSpreadsheet) GridDataRanges(ctx context.Context, ranges []string, fields string) (*sheets.Spreadsheet, error) {
+	f := func() (*sheets.Spreadsheet, error) {
+		return s.SheetsService.Spreadsheets.Get(s.ID).Ranges(ranges...).IncludeGridData(true).
+			Fields(googleapi.Field(fields)).Context(ctx).Do()
 	}
-	ret, err = RateLimit(f, 30*time.Second)
+	ret, err := Call(ctx, s.limiter, f)
 	if err != nil {
-		fmt.Printf("ReadCell error: %T/%v", err, err)
-		err = errors.Join(err, fmt.Errorf("ReadCell(%s!%s:%s)", sheet, start, end))
+		err = errors.Join(err, fmt.Errorf("GridDataRanges(%s, %v)", s.ID, ranges))
 	}
 	return ret, err
 }
@@ -0,0 +1,62 @@
+package ingest
+
+import (
+	"context"
+
+	ds "github.com/gczuczy/dw-stellar-density-analyzer/pkg/densitysurvey"
+)
+
+// Backfill retries the resolution of the stored unresolved points of at
+// most batch surveys, and returns the number of surveys processed and the
+// number of points resolved
+func (in *Ingestor) Backfill(ctx context.Context, batch int) (surveys int, resolved int, err error) {
+	ids, err := in.store.UnresolvedSurveys(ctx, batch)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, surveyid := range ids {
+		if err = ctx.Err(); err != nil {
+			return surveys, resolved, err
+		}
+		log := in.logger.With("surveyid", surveyid)
+
+		points, err := in.store.SurveyPoints(ctx, surveyid)
+		if err != nil {
+			log.Error("unable to load survey", "error", err)
+			continue
+		}
+
+		m := ds.Survey{
+			SurveyPoints: points,
+		}
+		before := pending(&m)
+		if err = m.LookupNames(ctx, in.resolver); err != nil {
+			log.Warn("lookup failed", "error", err)
+		}
+		for _, f := range m.ValidateGeometry(&in.cfg.Validation) {
+			log.Info("flag", "flag", f.String())
+		}
+
+		if err = in.store.UpdateSurveyPoints(ctx, surveyid, m.SurveyPoints); err != nil {
+			log.Error("unable to update survey", "error", err)
+			continue
+		}
+		surveys += 1
+		resolved += before - pending(&m)
+	}
+
+	in.logger.Info("backfill", "surveys", surveys, "resolved", resolved)
+	return surveys, resolved, nil
+}
+
+// pending is the number of points without authoritative coordinates
+func pending(m *ds.Survey) int {
+	n := 0
+	for _, dp := range m.SurveyPoints {
+		if !dp.Resolved || dp.CoordSource == ds.CoordSourceSheet {
+			n += 1
+		}
+	}
+	return n
+}
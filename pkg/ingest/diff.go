@@ -0,0 +1,42 @@
+package ingest
+
+import (
+	"errors"
+	"context"
+
+	ds "github.com/gczuczy/dw-stellar-density-analyzer/pkg/densitysurvey"
+)
+
+// DiffStore has the stored surveys of the spreadsheets, see db.DBPool. The
+// store is used for the diffs if it implements it.
+type DiffStore interface {
+	LatestSurveys(ctx context.Context, spreadsheetid string) (map[string][]ds.SurveyPoint, error)
+}
+
+// Diff compares the current contents of the entry's spreadsheet, with the
+// entry's overrides, with its latest stored surveys, without storing
+// anything
+func (in *Ingestor) Diff(ctx context.Context, e ds.Entry) (*ds.SpreadsheetDiff, error) {
+	sheetid := e.SheetID
+	s, ok := in.store.(DiffStore)
+	if !ok {
+		return nil, errors.New("The store can't list the stored surveys")
+	}
+
+	stored, err := s.LatestSurveys(ctx, sheetid)
+	if err != nil {
+		return nil, err
+	}
+
+	dss, err := ds.NewDensitySpreadsheet(ctx, sheetid, in.sheets, in.parser)
+	if err != nil {
+		return nil, err
+	}
+	dss.Override(e.CMDR, e.Campaign)
+	results, err := dss.Results(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return ds.DiffSpreadsheet(sheetid, stored, results), nil
+}
@@ -0,0 +1,426 @@
+package ingest
+
+import (
+	"fmt"
+	"time"
+	"errors"
+	"context"
+	"strings"
+	"log/slog"
+
+	"github.com/gczuczy/dw-stellar-density-analyzer/pkg/config"
+	ds "github.com/gczuczy/dw-stellar-density-analyzer/pkg/densitysurvey"
+)
+
+// Store persists the surveys, see db.DBPool
+type Store interface {
+	AddSurvey(ctx context.Context, m *ds.Survey) error
+	UnresolvedSurveys(ctx context.Context, limit int) ([]int, error)
+	SurveyPoints(ctx context.Context, surveyid int) ([]ds.SurveyPoint, error)
+	UpdateSurveyPoints(ctx context.Context, surveyid int, points []ds.SurveyPoint) error
+}
+
+// Archive keeps the raw values of the ingested tabs, see db.DBPool. The
+// store is used as the archive if it implements it.
+type Archive interface {
+	ArchiveTab(ctx context.Context, t *ds.RawTab) error
+	ArchivedTabs(ctx context.Context) ([]ds.RawTab, error)
+	ReplaceSurveys(ctx context.Context, spreadsheetid, tab, source string, ms []ds.Survey) error
+}
+
+// RevisionSource tells the current revision of a spreadsheet, see
+// google.GDriveService
+type RevisionSource interface {
+	Revision(ctx context.Context, id string) (string, error)
+}
+
+// Ingestor reads the survey spreadsheets, resolves and validates their
+// points, and stores them. It has no global state, multiple ones with
+// different configurations can be used in one process.
+type Ingestor struct {
+	sheets ds.SheetSource
+	resolver ds.CoordinateResolver
+	store Store
+	logger *slog.Logger
+	cfg *config.Config
+	parser *ds.Parser
+	revisions RevisionSource
+	// the name of the source, see ForSource
+	source string
+}
+
+// New creates an Ingestor, the logger defaults to slog.Default. The store
+// can be nil for linting only.
+func New(sheets ds.SheetSource, resolver ds.CoordinateResolver, store Store,
+	logger *slog.Logger, cfg *config.Config) (*Ingestor, error) {
+
+	parser, err := ds.NewParser(&cfg.Variants, &cfg.Metadata)
+	if err != nil {
+		return nil, errors.Join(err, errors.New("Sheet variant error"))
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &Ingestor{
+		sheets: sheets,
+		resolver: resolver,
+		store: store,
+		logger: logger,
+		cfg: cfg,
+		parser: parser,
+	}, nil
+}
+
+// SetRevisionSource sets where the revisions of the archived spreadsheets
+// are taken from, without one they are not recorded
+func (in *Ingestor) SetRevisionSource(r RevisionSource) {
+	in.revisions = r
+}
+
+// ForSource derives the Ingestor of a configured source, parsing with its
+// variants, campaign and schedule. Everything else is shared.
+func (in *Ingestor) ForSource(cfg *config.SourceConfig) (*Ingestor, error) {
+	parser, err := in.parser.ForSource(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := *in
+	ret.parser = parser
+	ret.source = cfg.Name
+	ret.logger = in.logger.With("source", cfg.Name)
+	return &ret, nil
+}
+
+// EntrySource lists the spreadsheets to ingest, see ds.EntrySheet and
+// ds.FolderDiscovery
+type EntrySource interface {
+	GetEntries(ctx context.Context) ([]ds.Entry, error)
+}
+
+// StatusWriter is a source reporting the outcome back to its entries
+type StatusWriter interface {
+	WriteStatus(ctx context.Context, statuses []ds.EntryStatus) error
+}
+
+// EntrySheet opens the entry sheet as a source
+func (in *Ingestor) EntrySheet(ctx context.Context, entryid string) (*ds.EntrySheet, error) {
+	return ds.NewEntrySheet(ctx, entryid, in.sheets, &in.cfg.EntrySheet)
+}
+
+// IngestEntrySheet ingests all the spreadsheets listed on the entry sheet.
+// The error is only set when the entry sheet couldn't be read, the problems
+// of the individual spreadsheets are in the report.
+func (in *Ingestor) IngestEntrySheet(ctx context.Context, entryid string) (*Report, error) {
+	entry, err := in.EntrySheet(ctx, entryid)
+	if err != nil {
+		return &Report{}, err
+	}
+	report, err := in.Ingest(ctx, entry)
+	if in.source == "" {
+		report.Key = EntrySheetKey(entryid)
+	}
+	return report, err
+}
+
+// Ingest ingests the spreadsheets of all the sources, each of them once,
+// with the overrides of its first entry. The sources implementing
+// StatusWriter get the outcome of their entries. The error is only set when
+// none of the sources could be listed.
+func (in *Ingestor) Ingest(ctx context.Context, sources ...EntrySource) (*Report, error) {
+	var reterr error = nil
+	report := &Report{
+		Source: in.source,
+	}
+	if in.source != "" {
+		report.Key = SourceKey(in.source)
+	}
+
+	listed := make([][]ds.Entry, len(sources))
+	for i, src := range sources {
+		entries, err := src.GetEntries(ctx)
+		if err != nil {
+			in.logger.Warn("spreadsheet source incomplete", "error", err)
+			reterr = errors.Join(reterr, err)
+			report.Incomplete = true
+		}
+		listed[i] = entries
+	}
+
+	reports := map[string]*SheetReport{}
+	nentries := 0
+	for _, entries := range listed {
+		nentries += len(entries)
+		for _, e := range entries {
+			if e.Skip {
+				report.Excluded = append(report.Excluded, e.SheetID)
+				continue
+			}
+			if _, ok := reports[e.SheetID]; ok {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return report, err
+			}
+			sr := in.IngestEntry(ctx, e)
+			reports[e.SheetID] = sr
+			report.Sheets = append(report.Sheets, sr)
+		}
+	}
+	if nentries == 0 && reterr != nil {
+		return report, reterr
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	for i, src := range sources {
+		sw, ok := src.(StatusWriter)
+		if !ok {
+			continue
+		}
+		statuses := make([]ds.EntryStatus, 0, len(listed[i]))
+		for _, e := range listed[i] {
+			statuses = append(statuses, entryStatus(&e, reports[e.SheetID], now))
+		}
+		if err := sw.WriteStatus(ctx, statuses); err != nil {
+			in.logger.Error("unable to write the entry status", "error", err)
+			report.Errors = append(report.Errors, err)
+		}
+	}
+
+	return report, nil
+}
+
+// Locate finds the entry of the spreadsheet in the sources, the first one
+// not skipping it, as Ingest would pick its overrides. The sources failing
+// to be listed are left out.
+func (in *Ingestor) Locate(ctx context.Context, sheetid string, sources ...EntrySource) (ds.Entry, bool) {
+	for _, src := range sources {
+		entries, err := src.GetEntries(ctx)
+		if err != nil {
+			in.logger.Warn("spreadsheet source incomplete", "error", err)
+		}
+		for _, e := range entries {
+			if e.SheetID == sheetid && !e.Skip {
+				return e, true
+			}
+		}
+	}
+	return ds.Entry{}, false
+}
+
+// IngestSpreadsheet ingests the surveys of a single spreadsheet
+func (in *Ingestor) IngestSpreadsheet(ctx context.Context, sheetid string) *SheetReport {
+	return in.IngestEntry(ctx, ds.Entry{
+		SheetID: sheetid,
+		Row: -1,
+	})
+}
+
+// IngestEntry ingests the surveys of a spreadsheet with the entry's overrides
+func (in *Ingestor) IngestEntry(ctx context.Context, e ds.Entry) *SheetReport {
+	sheetid := e.SheetID
+	sr := newSheetReport(sheetid)
+	sr.Notes = e.Notes
+	log := in.logger.With("sheetid", sheetid)
+	log.Info("ingesting spreadsheet")
+
+	dss, err := ds.NewDensitySpreadsheet(ctx, sheetid, in.sheets, in.parser)
+	if err != nil {
+		log.Error("unable to open spreadsheet", "error", err)
+		sr.Errors = append(sr.Errors, err)
+		return sr
+	}
+	dss.Override(e.CMDR, e.Campaign)
+
+	tabs, err := dss.Fetch(ctx)
+	if err != nil {
+		log.Error("unable to read spreadsheet", "error", err)
+		sr.Errors = append(sr.Errors, err)
+		return sr
+	}
+	sr.Tabs = make([]string, 0, len(tabs))
+	for i := range tabs {
+		tabs[i].Source = in.source
+		sr.Tabs = append(sr.Tabs, tabs[i].Tab)
+	}
+	in.archive(ctx, log, sr, tabs)
+	results := in.parser.Parse(tabs)
+	ms := in.process(ctx, log, sr, results)
+	if in.cfg.Annotate {
+		if err = dss.Annotate(ctx, results, ms); err != nil {
+			log.Error("annotate failed", "error", err)
+			sr.Errors = append(sr.Errors, err)
+		}
+	}
+
+	writeback := []ds.Survey{}
+	for _, m := range in.save(ctx, log, sr, ms) {
+		if writebackEnabled(&in.cfg.Writeback, &m) {
+			writeback = append(writeback, m)
+		}
+	}
+
+	if len(writeback) > 0 {
+		if err = dss.WriteResults(ctx, in.cfg.Writeback.Tab, writeback); err != nil {
+			log.Error("writeback failed", "error", err)
+			sr.Errors = append(sr.Errors, err)
+		}
+	}
+
+	return sr
+}
+
+// archive stores the raw tabs when archiving is enabled, setting their IDs
+func (in *Ingestor) archive(ctx context.Context, log *slog.Logger, sr *SheetReport, tabs []ds.RawTab) {
+	a, ok := in.store.(Archive)
+	if !in.cfg.Archive.Enabled || !ok || len(tabs) == 0 {
+		return
+	}
+
+	revision := ""
+	if in.revisions != nil {
+		var err error
+		if revision, err = in.revisions.Revision(ctx, tabs[0].SpreadsheetID); err != nil {
+			log.Warn("unable to get the revision", "error", err)
+		}
+	}
+	for i := range tabs {
+		tabs[i].Revision = revision
+		if err := a.ArchiveTab(ctx, &tabs[i]); err != nil {
+			log.Error("unable to archive", "tab", tabs[i].Tab, "error", err)
+			sr.Errors = append(sr.Errors, err)
+		}
+	}
+}
+
+// IngestForm ingests the responses of a Google Forms response spreadsheet,
+// each of them is a survey
+func (in *Ingestor) IngestForm(ctx context.Context, sheetid string) *SheetReport {
+	sr := newSheetReport(sheetid)
+	log := in.logger.With("sheetid", sheetid)
+	log.Info("ingesting form responses")
+
+	fr, err := ds.NewFormResponses(ctx, sheetid, in.sheets, &in.cfg.Forms)
+	if err != nil {
+		log.Error("unable to open spreadsheet", "error", err)
+		sr.Errors = append(sr.Errors, err)
+		return sr
+	}
+
+	results, err := fr.Results(ctx)
+	if err != nil {
+		log.Error("unable to read form responses", "error", err)
+		sr.Errors = append(sr.Errors, err)
+		return sr
+	}
+	in.save(ctx, log, sr, in.process(ctx, log, sr, results))
+
+	return sr
+}
+
+// IngestForms ingests the configured form response spreadsheets, adding
+// them to the report, which is keyed as FormsKey if it has no key yet
+func (in *Ingestor) IngestForms(ctx context.Context, report *Report) {
+	if report.Key == "" {
+		report.Key = FormsKey
+	}
+	for _, sheet := range in.cfg.Forms.Sheets {
+		if err := ctx.Err(); err != nil {
+			report.Errors = append(report.Errors, err)
+			report.Incomplete = true
+			return
+		}
+		id, err := ds.SpreadsheetID(sheet)
+		if err != nil {
+			report.Errors = append(report.Errors, errors.Join(err, fmt.Errorf("Invalid forms sheet %q", sheet)))
+			report.Incomplete = true
+			continue
+		}
+		report.Sheets = append(report.Sheets, in.IngestForm(ctx, id))
+	}
+}
+
+// process resolves and validates the surveys parsed successfully
+func (in *Ingestor) process(ctx context.Context, log *slog.Logger, sr *SheetReport,
+	results []ds.TabResult) []ds.Survey {
+
+	ms := []ds.Survey{}
+	for _, res := range results {
+		var uverr *ds.UnknownVariantError
+		switch {
+		case res.Err == nil:
+			ms = append(ms, *res.Survey)
+		case errors.As(res.Err, &uverr):
+			// not every tab is a survey
+			sr.Ignored = append(sr.Ignored, res.Tab)
+		default:
+			sr.Errors = append(sr.Errors, res.Err)
+		}
+	}
+	for i := range ms {
+		sr.Skipped = append(sr.Skipped, ms[i].Issues...)
+		sr.Flags = append(sr.Flags, ms[i].ValidateSchedule()...)
+		if err := ms[i].LookupNames(ctx, in.resolver); err != nil {
+			log.Warn("lookup failed", "tab", ms[i].Name, "error", err)
+			sr.Errors = append(sr.Errors, err)
+		}
+		if names := ms[i].Unresolved(); len(names) > 0 {
+			sr.Unresolved = append(sr.Unresolved, names...)
+		}
+		for _, dp := range ms[i].SurveyPoints {
+			if dp.Resolved {
+				sr.Sources[dp.CoordSource] += 1
+			}
+		}
+		sr.Flags = append(sr.Flags, ms[i].ValidateGeometry(&in.cfg.Validation)...)
+	}
+
+	return ms
+}
+
+// save stores the surveys, and returns the ones stored
+func (in *Ingestor) save(ctx context.Context, log *slog.Logger, sr *SheetReport,
+	ms []ds.Survey) []ds.Survey {
+
+	ret := []ds.Survey{}
+	for _, m := range ms {
+		if err := in.store.AddSurvey(ctx, &m); err != nil {
+			log.Error("unable to store survey", "tab", m.Name, "error", err)
+			sr.Errors = append(sr.Errors, err)
+			continue
+		}
+		sr.Surveys += 1
+		sr.Points += len(m.SurveyPoints)
+		ret = append(ret, m)
+	}
+
+	return ret
+}
+
+// entryStatus is the status of the entry from its spreadsheet's report,
+// which is nil for the skipped ones
+func entryStatus(e *ds.Entry, sr *SheetReport, now string) ds.EntryStatus {
+	st := ds.EntryStatus{
+		Row: e.Row,
+		LastIngested: now,
+	}
+	if sr == nil {
+		st.Result = "skipped"
+		return st
+	}
+	st.Result = sr.Result()
+	st.Points = sr.Points
+	st.Errors = sr.ErrorSummary()
+	return st
+}
+
+// writebackEnabled tells whether the survey's campaign opted in for writeback
+func writebackEnabled(cfg *config.WritebackConfig, m *ds.Survey) bool {
+	for _, c := range cfg.Campaigns {
+		if strings.EqualFold(strings.TrimSpace(m.Project), c) {
+			return true
+		}
+	}
+	return false
+}
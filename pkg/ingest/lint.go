@@ -0,0 +1,45 @@
+package ingest
+
+import (
+	"context"
+
+	ds "github.com/gczuczy/dw-stellar-density-analyzer/pkg/densitysurvey"
+)
+
+// Lint explains how the entry's spreadsheet would be ingested with the
+// entry's overrides: per tab the variants tried, the rows skipped, the
+// systems not resolved and the points flagged. Nothing is stored, the store
+// is not used.
+func (in *Ingestor) Lint(ctx context.Context, e ds.Entry) (*ds.LintReport, error) {
+	sheetid := e.SheetID
+	dss, err := ds.NewDensitySpreadsheet(ctx, sheetid, in.sheets, in.parser)
+	if err != nil {
+		return nil, err
+	}
+	dss.Override(e.CMDR, e.Campaign)
+	tabs, err := dss.Fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ds.LintReport{
+		SpreadsheetID: sheetid,
+		Tabs: in.parser.Lint(tabs),
+	}
+	for i := range report.Tabs {
+		tl := &report.Tabs[i]
+		if tl.Survey == nil {
+			continue
+		}
+		if err = tl.Survey.LookupNames(ctx, in.resolver); err != nil {
+			tl.Errors = append(tl.Errors, err.Error())
+			continue
+		}
+		tl.Unresolved = append(tl.Unresolved, tl.Survey.Unresolved()...)
+		for _, f := range tl.Survey.ValidateGeometry(&in.cfg.Validation) {
+			tl.Flags = append(tl.Flags, f.String())
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,132 @@
+package ingest
+
+import (
+	"fmt"
+	"errors"
+	"context"
+
+	ds "github.com/gczuczy/dw-stellar-density-analyzer/pkg/densitysurvey"
+)
+
+// Reparse parses the latest archived version of every tab again, without
+// reading the spreadsheets. Without replace it only reports the outcome,
+// with it the surveys of the tabs parsed successfully are resolved,
+// validated and stored instead of the ones parsed earlier; the tabs failing
+// now keep their stored surveys. The tabs are parsed with the parser of the
+// source they were ingested from, the ones ingested by several sources once
+// for each of them, replacing only that source's surveys. The retracted tabs are left out, and the
+// retracted surveys are kept as they are.
+func (in *Ingestor) Reparse(ctx context.Context, replace bool) (*Report, error) {
+	report := &Report{}
+
+	a, ok := in.store.(Archive)
+	if !ok {
+		return report, errors.New("The store has no archive")
+	}
+	tabs, err := a.ArchivedTabs(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	// grouped by spreadsheet, they are ordered by it
+	sources := map[string]*Ingestor{"": in}
+	for len(tabs) > 0 {
+		n := 1
+		for n < len(tabs) && tabs[n].SpreadsheetID == tabs[0].SpreadsheetID {
+			n += 1
+		}
+		if err = ctx.Err(); err != nil {
+			return report, err
+		}
+		// each tab is parsed as its source did
+		bysource := map[string][]ds.RawTab{}
+		names := []string{}
+		for _, t := range tabs[:n] {
+			if _, ok := bysource[t.Source]; !ok {
+				names = append(names, t.Source)
+			}
+			bysource[t.Source] = append(bysource[t.Source], t)
+		}
+		for _, name := range names {
+			src, err := in.sourceIngestor(name, sources)
+			if err != nil {
+				sr := newSheetReport(tabs[0].SpreadsheetID)
+				sr.Errors = append(sr.Errors, err)
+				report.Sheets = append(report.Sheets, sr)
+				continue
+			}
+			report.Sheets = append(report.Sheets, src.reparse(ctx, a, bysource[name], replace))
+		}
+		tabs = tabs[n:]
+	}
+
+	return report, nil
+}
+
+// sourceIngestor is the Ingestor of the configured source by its name,
+// cached in sources
+func (in *Ingestor) sourceIngestor(name string, sources map[string]*Ingestor) (*Ingestor, error) {
+	if src, ok := sources[name]; ok {
+		return src, nil
+	}
+	for i := range in.cfg.Sources {
+		if in.cfg.Sources[i].Name != name {
+			continue
+		}
+		src, err := in.ForSource(&in.cfg.Sources[i])
+		if err != nil {
+			return nil, err
+		}
+		sources[name] = src
+		return src, nil
+	}
+	return nil, fmt.Errorf("The source %q is not configured anymore", name)
+}
+
+func (in *Ingestor) reparse(ctx context.Context, a Archive, tabs []ds.RawTab, replace bool) *SheetReport {
+	sheetid := tabs[0].SpreadsheetID
+	sr := newSheetReport(sheetid)
+	log := in.logger.With("sheetid", sheetid)
+	log.Info("reparsing spreadsheet", "tabs", len(tabs))
+
+	results := in.parser.Parse(tabs)
+	if !replace {
+		for _, res := range results {
+			var uverr *ds.UnknownVariantError
+			switch {
+			case res.Err == nil:
+				sr.Surveys += 1
+				sr.Points += len(res.Survey.SurveyPoints)
+				sr.Skipped = append(sr.Skipped, res.Survey.Issues...)
+				sr.Flags = append(sr.Flags, res.Survey.ValidateSchedule()...)
+			case errors.As(res.Err, &uverr):
+				sr.Ignored = append(sr.Ignored, res.Tab)
+			default:
+				sr.Errors = append(sr.Errors, res.Err)
+			}
+		}
+		return sr
+	}
+
+	bytab := map[string][]ds.Survey{}
+	for _, m := range in.process(ctx, log, sr, results) {
+		bytab[m.Name] = append(bytab[m.Name], m)
+	}
+	for _, res := range results {
+		if res.Err != nil {
+			continue
+		}
+		ms := bytab[res.Tab]
+		if err := a.ReplaceSurveys(ctx, sheetid, res.Tab, in.source, ms); err != nil {
+			log.Error("unable to replace the surveys", "tab", res.Tab, "error", err)
+			sr.Errors = append(sr.Errors, err)
+			continue
+		}
+		sr.Surveys += len(ms)
+		for _, m := range ms {
+			sr.Points += len(m.SurveyPoints)
+		}
+	}
+
+	return sr
+}
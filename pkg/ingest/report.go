@@ -0,0 +1,136 @@
+package ingest
+
+import (
+	"io"
+	"fmt"
+	"strings"
+
+	ds "github.com/gczuczy/dw-stellar-density-analyzer/pkg/densitysurvey"
+)
+
+// Report is the outcome of an ingest run
+type Report struct {
+	// the name of the configured source, empty for the default one
+	Source string
+	// identifies the source across the runs for the retraction, see
+	// SourceKey. Reports without one are not recorded.
+	Key string
+	Sheets []*SheetReport
+	// the spreadsheets skipped on the entry sheet
+	Excluded []string
+	// not every spreadsheet could be listed, nothing of the source is
+	// retracted
+	Incomplete bool
+	Errors []error
+}
+
+// SheetReport is the outcome of ingesting a spreadsheet
+type SheetReport struct {
+	SheetID string
+	Surveys int
+	Points int
+	Flags []ds.ValidationFlag
+	Unresolved []string
+	// tabs not recognized as surveys
+	Ignored []string
+	// rows skipped while parsing
+	Skipped []ds.CellIssue
+	// number of points per coordinate source
+	Sources map[string]int
+	// the coordinators' notes from the entry sheet
+	Notes string
+	// the tabs read, nil if the spreadsheet couldn't be read
+	Tabs []string
+	Errors []error
+}
+
+func newSheetReport(sheetid string) *SheetReport {
+	return &SheetReport{
+		SheetID: sheetid,
+		Sources: map[string]int{},
+	}
+}
+
+// Result is the short outcome of the spreadsheet
+func (sr *SheetReport) Result() string {
+	switch {
+	case len(sr.Errors) == 0 && sr.Surveys > 0:
+		return "ok"
+	case len(sr.Errors) == 0:
+		return "no surveys"
+	case sr.Surveys > 0:
+		return "partial"
+	}
+	return "failed"
+}
+
+// ErrorSummary is the first error in a line, and the number of the rest
+func (sr *SheetReport) ErrorSummary() string {
+	if len(sr.Errors) == 0 {
+		return ""
+	}
+	msg := strings.Join(strings.Fields(sr.Errors[0].Error()), " ")
+	if r := []rune(msg); len(r) > 200 {
+		msg = string(r[:200]) + "..."
+	}
+	if len(sr.Errors) > 1 {
+		msg += fmt.Sprintf(" (+%d more)", len(sr.Errors)-1)
+	}
+	return msg
+}
+
+// Write prints the human readable report
+func (r *Report) Write(w io.Writer) {
+	var surveys, points, flags, errs int
+
+	if r.Source != "" {
+		fmt.Fprintf(w, "\n=== Run report: %s ===\n", r.Source)
+	} else {
+		fmt.Fprintf(w, "\n=== Run report ===\n")
+	}
+	for _, sr := range r.Sheets {
+		fmt.Fprintf(w, "%s: surveys:%d points:%d unresolved:%d flags:%d errors:%d\n", sr.SheetID,
+			sr.Surveys, sr.Points, len(sr.Unresolved), len(sr.Flags), len(sr.Errors))
+		if sr.Notes != "" {
+			fmt.Fprintf(w, "  notes: %s\n", sr.Notes)
+		}
+		if len(sr.Ignored) > 0 {
+			fmt.Fprintf(w, "  not surveys: %v\n", sr.Ignored)
+		}
+		if len(sr.Sources) > 0 {
+			fmt.Fprintf(w, "  coordinate sources: %v\n", sr.Sources)
+		}
+		for _, issue := range sr.Skipped {
+			if issue.Err != nil {
+				fmt.Fprintf(w, "  issue: %s: %v\n", issue.Message, issue.Err)
+			} else {
+				fmt.Fprintf(w, "  issue: %s\n", issue.Message)
+			}
+		}
+		for _, name := range sr.Unresolved {
+			fmt.Fprintf(w, "  unresolved: %s\n", name)
+		}
+		for _, f := range sr.Flags {
+			fmt.Fprintf(w, "  flag: %s\n", f)
+		}
+		for _, err := range sr.Errors {
+			fmt.Fprintf(w, "  error: %v\n", err)
+		}
+		surveys += sr.Surveys
+		points += sr.Points
+		flags += len(sr.Flags)
+		errs += len(sr.Errors)
+	}
+	if len(r.Excluded) > 0 {
+		fmt.Fprintf(w, "Skipped on the entry sheet: %v\n", r.Excluded)
+	}
+	if r.Incomplete {
+		fmt.Fprintf(w, "Not every source could be listed, nothing retracted\n")
+	}
+	for _, err := range r.Errors {
+		fmt.Fprintf(w, "error: %v\n", err)
+	}
+	errs += len(r.Errors)
+	fmt.Fprintf(w, "Total: sheets:%d surveys:%d points:%d flags:%d errors:%d\n",
+		len(r.Sheets), surveys, points, flags, errs)
+}
@@ -0,0 +1,126 @@
+package ingest
+
+import (
+	"errors"
+	"context"
+
+	ds "github.com/gczuczy/dw-stellar-density-analyzer/pkg/densitysurvey"
+)
+
+// RunStore records the ingest runs and retracts the surveys whose source
+// disappeared, see db.DBPool. The store is used for it if it implements it.
+type RunStore interface {
+	RecordRun(ctx context.Context, complete []string, seen map[string]map[string][]string) (int, error)
+	RetractedSurveys(ctx context.Context, spreadsheetids []string) ([]ds.RetractedSurvey, error)
+	RestoreSurveys(ctx context.Context, spreadsheetids []string) (int, error)
+	PurgeSurveys(ctx context.Context, spreadsheetids []string) (int, error)
+}
+
+const (
+	// the key of the form responses
+	FormsKey = "forms"
+	// the key of discovery.folders
+	DiscoveryKey = "discovery"
+)
+
+// SourceKey is the key of a configured source
+func SourceKey(name string) string {
+	return "source:" + name
+}
+
+// EntrySheetKey is the key of an entry sheet outside the configured sources
+func EntrySheetKey(entryid string) string {
+	return "entrysheet:" + entryid
+}
+
+// Retract records the spreadsheets and tabs seen by the run under their
+// source, and marks the stored surveys retracted which were seen by the
+// previous run of a source, but not by this one. Only the sources listed
+// completely are compared, and a spreadsheet moved to another source is
+// still seen. The spreadsheets skipped on the entry sheet, and the ones
+// which couldn't be read keep their surveys. Returns the number of surveys
+// retracted.
+func (in *Ingestor) Retract(ctx context.Context, reports ...*Report) (int, error) {
+	rs, ok := in.store.(RunStore)
+	if !ok {
+		return 0, nil
+	}
+
+	complete := []string{}
+	seen := map[string]map[string][]string{}
+	for _, report := range reports {
+		if report.Key == "" {
+			continue
+		}
+		if !report.Incomplete {
+			complete = append(complete, report.Key)
+		}
+		sheets, ok := seen[report.Key]
+		if !ok {
+			sheets = map[string][]string{}
+			seen[report.Key] = sheets
+		}
+		for _, id := range report.Excluded {
+			if _, ok := sheets[id]; !ok {
+				sheets[id] = nil
+			}
+		}
+		for _, sr := range report.Sheets {
+			if sr.Tabs != nil || sheets[sr.SheetID] == nil {
+				sheets[sr.SheetID] = sr.Tabs
+			}
+		}
+	}
+	if len(seen) == 0 {
+		return 0, nil
+	}
+
+	n, err := rs.RecordRun(ctx, complete, seen)
+	if err != nil {
+		return 0, errors.Join(err, errors.New("Unable to record the run"))
+	}
+	if n > 0 {
+		in.logger.Info("surveys retracted", "surveys", n)
+	}
+	return n, nil
+}
+
+// runStore is the store as a RunStore
+func (in *Ingestor) runStore() (RunStore, error) {
+	rs, ok := in.store.(RunStore)
+	if !ok {
+		return nil, errors.New("The store doesn't track the retractions")
+	}
+	return rs, nil
+}
+
+// RetractedSurveys lists the retracted surveys of the spreadsheets, all of
+// them without any
+func (in *Ingestor) RetractedSurveys(ctx context.Context, spreadsheetids []string) ([]ds.RetractedSurvey, error) {
+	rs, err := in.runStore()
+	if err != nil {
+		return nil, err
+	}
+	return rs.RetractedSurveys(ctx, spreadsheetids)
+}
+
+// RestoreSurveys clears the retraction of the spreadsheets' surveys, all of
+// them without any. They are not retracted again until their source is
+// seen and disappears once more.
+func (in *Ingestor) RestoreSurveys(ctx context.Context, spreadsheetids []string) (int, error) {
+	rs, err := in.runStore()
+	if err != nil {
+		return 0, err
+	}
+	return rs.RestoreSurveys(ctx, spreadsheetids)
+}
+
+// PurgeSurveys deletes the retracted surveys of the spreadsheets, all of
+// them without any
+func (in *Ingestor) PurgeSurveys(ctx context.Context, spreadsheetids []string) (int, error) {
+	rs, err := in.runStore()
+	if err != nil {
+		return 0, err
+	}
+	return rs.PurgeSurveys(ctx, spreadsheetids)
+}
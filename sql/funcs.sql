@@ -1,26 +1,80 @@
-
-CREATE OR REPLACE FUNCTION density.addsheetsurvey(cmdr text, campaign text) RETURNS int AS $$
+CREATE OR REPLACE FUNCTION density.addsheetsurvey(cmdr text, campaign text, flags text[],
+       submitted timestamptz, respondent text, rawtabid int, spreadsheetid text, tab text,
+       surveydate date, notes text, source text)
+       RETURNS int AS $$
 DECLARE
 	cmdrid int;
 	campaignid int;
 	mid int;
 BEGIN
+   IF coalesce(cmdr, '') = '' OR coalesce(campaign, '') = '' THEN
+      RAISE EXCEPTION 'survey %/% has no CMDR or campaign', spreadsheetid, tab;
+   END IF;
+
    SELECT INTO cmdrid id FROM density.cmdrs WHERE name = cmdr;
    IF NOT FOUND THEN
       INSERT INTO density.cmdrs (name) VALUES (cmdr) RETURNING id INTO cmdrid;
    END IF;
 
-   SELECT INTO campaignid id FROM density.campaigns WHERE name = campaign;
+   -- the campaign by its name, then by its aliases
+   SELECT INTO campaignid c.id FROM density.campaigns c WHERE lower(c.name) = lower(campaign);
+   IF NOT FOUND THEN
+      SELECT INTO campaignid ca.campaignid FROM density.campaignaliases ca
+      WHERE lower(ca.alias) = lower(campaign);
+   END IF;
    IF NOT FOUND THEN
       INSERT INTO density.campaigns (name) VALUES (campaign)
       RETURNING id INTO campaignid;
    END IF;
 
-   INSERT INTO density.surveys (cmdrid, campaignid) VALUES (cmdrid, campaignid)
+   INSERT INTO density.surveys (cmdrid, campaignid, flags, submitted, respondent, rawtabid,
+          spreadsheetid, tab, surveydate, notes, source)
+   VALUES (cmdrid, campaignid, flags, submitted, respondent, rawtabid, spreadsheetid, tab,
+   	  surveydate, notes, source)
    RETURNING id INTO mid;
 
    RETURN mid;
 END;
-$$ LANGUAGE plpgsql VOLATILE STRICT PARALLEL UNSAFE SECURITY INVOKER;
+$$ LANGUAGE plpgsql VOLATILE PARALLEL UNSAFE SECURITY INVOKER;
+
+GRANT EXECUTE ON FUNCTION density.addsheetsurvey(cmdr text, campaign text, flags text[],
+      submitted timestamptz, respondent text, rawtabid int, spreadsheetid text, tab text,
+      surveydate date, notes text, source text) TO edservice;
+
+-- retract marks the surveys retracted which disappeared since the previous
+-- run of a source this run listed completely: the spreadsheets seen under
+-- the source then, but not seen by this run at all, and the tabs seen then
+-- of the spreadsheets this run read, but not among the tabs read now.
+CREATE OR REPLACE FUNCTION density.retract(run int)
+       RETURNS int AS $$
+DECLARE
+	n int;
+BEGIN
+   WITH prev AS (
+   SELECT p.spreadsheetid, p.tab
+   FROM density.runsources rs
+   	JOIN density.runsheets p ON p.source = rs.source
+	     AND p.runid = (SELECT max(r.runid) FROM density.runsources r
+	     	 	    WHERE r.source = rs.source AND r.runid < run)
+   WHERE rs.runid = run
+   )
+   UPDATE density.surveys s SET retracted = now()
+   WHERE s.retracted IS NULL AND s.spreadsheetid IS NOT NULL
+   AND (
+       -- the spreadsheet is gone
+       (EXISTS (SELECT 1 FROM prev WHERE prev.spreadsheetid = s.spreadsheetid AND prev.tab IS NULL)
+        AND NOT EXISTS (SELECT 1 FROM density.runsheets c
+       	    	        WHERE c.runid = run AND c.spreadsheetid = s.spreadsheetid))
+       -- the tab is gone from a spreadsheet read
+       OR (EXISTS (SELECT 1 FROM prev WHERE prev.spreadsheetid = s.spreadsheetid AND prev.tab = s.tab)
+           AND EXISTS (SELECT 1 FROM density.runsheets c
+	       	       WHERE c.runid = run AND c.spreadsheetid = s.spreadsheetid AND c.tab IS NOT NULL)
+	   AND NOT EXISTS (SELECT 1 FROM density.runsheets c
+	       	       	   WHERE c.runid = run AND c.spreadsheetid = s.spreadsheetid AND c.tab = s.tab)));
+   GET DIAGNOSTICS n = ROW_COUNT;
+
+   RETURN n;
+END;
+$$ LANGUAGE plpgsql VOLATILE PARALLEL UNSAFE SECURITY INVOKER;
 
-GRANT EXECUTE ON FUNCTION density.addsheetsurvey(cmdr text, campaign text) TO edservice;
+GRANT EXECUTE ON FUNCTION density.retract(run int) TO edservice;
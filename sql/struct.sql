@@ -16,6 +16,27 @@ INSERT INTO density.campaigns (name) VALUES
 ('DW3 Logarithmic Density Scans')
 ;
 
+-- free-text project names of the sheets mapped onto the campaigns,
+-- matched case-insensitively
+CREATE TABLE density.campaignaliases (
+       alias varchar(64)	  NOT NULL,
+       campaignid int		  NOT NULL,
+       FOREIGN KEY (campaignid) REFERENCES density.campaigns (id),
+       PRIMARY KEY (alias)
+);
+CREATE UNIQUE INDEX campaignaliases_lower_idx ON density.campaignaliases (lower(alias));
+GRANT SELECT ON density.campaignaliases TO edservice;
+GRANT SELECT ON density.campaignaliases TO edviewer;
+INSERT INTO density.campaignaliases (alias, campaignid)
+SELECT a.alias, c.id
+FROM (VALUES ('DW3', 'DW3 Stellar Density Scans'),
+     	     ('DW3 Density Scans', 'DW3 Stellar Density Scans'),
+	     ('DW3 Log', 'DW3 Logarithmic Density Scans'),
+	     ('DW3 Logarithmic', 'DW3 Logarithmic Density Scans'),
+	     ('A15X', 'A15X CW Density Scans')) a(alias, campaign)
+     JOIN density.campaigns c ON c.name = a.campaign
+;
+
 CREATE TABLE density.cmdrs (
        id    int		  GENERATED ALWAYS AS IDENTITY,
        name  varchar(64)	  NOT NULL UNIQUE,
@@ -24,33 +45,122 @@ CREATE TABLE density.cmdrs (
 GRANT SELECT, INSERT, UPDATE ON density.cmdrs TO edservice;
 GRANT SELECT ON density.cmdrs TO edviewer;
 
+-- the raw values of the ingested sheets, to parse them again
+CREATE TABLE density.rawtabs (
+       id    int		  GENERATED ALWAYS AS IDENTITY,
+       spreadsheetid varchar(64)  NOT NULL,
+       tab	     varchar(128) NOT NULL,
+       fetched	     timestamptz  NOT NULL DEFAULT now(),
+       -- the Drive version of the spreadsheet
+       revision	     varchar(32),
+       -- the spreadsheet's title
+       title	     varchar(256),
+       -- the entry sheet's overrides
+       cmdr	     varchar(64),
+       campaign	     varchar(64),
+       -- the configured source it was ingested from, NULL for the default
+       source	     varchar(128),
+       -- gzipped JSON of the unformatted values, and the SHA-256 of the JSON
+       data	     bytea	  NOT NULL,
+       hash	     bytea,
+       PRIMARY KEY (id)
+);
+CREATE INDEX rawtabs_tab_idx ON density.rawtabs (spreadsheetid, tab, fetched DESC);
+GRANT SELECT, INSERT ON density.rawtabs TO edservice;
+
+-- the ingest runs
+CREATE TABLE density.runs (
+       id    int		  GENERATED ALWAYS AS IDENTITY,
+       finished	     timestamptz  NOT NULL DEFAULT now(),
+       PRIMARY KEY (id)
+);
+GRANT SELECT, INSERT ON density.runs TO edservice;
+GRANT SELECT ON density.runs TO edviewer;
+
+-- the sources the runs listed completely: a configured source's name,
+-- the entry sheet's ID, discovery or forms
+CREATE TABLE density.runsources (
+       runid int		  NOT NULL,
+       source varchar(128)	  NOT NULL,
+       FOREIGN KEY (runid) REFERENCES density.runs(id),
+       PRIMARY KEY (runid, source)
+);
+CREATE INDEX runsources_source_idx ON density.runsources (source, runid);
+GRANT SELECT, INSERT ON density.runsources TO edservice;
+GRANT SELECT ON density.runsources TO edviewer;
+
+-- the spreadsheets seen by the runs under their source with a NULL tab,
+-- and the tabs of the ones read
+CREATE TABLE density.runsheets (
+       runid int		  NOT NULL,
+       source varchar(128)	  NOT NULL,
+       spreadsheetid varchar(64)  NOT NULL,
+       tab	     varchar(128),
+       FOREIGN KEY (runid) REFERENCES density.runs(id)
+);
+CREATE INDEX runsheets_idx ON density.runsheets (runid, spreadsheetid, tab);
+CREATE INDEX runsheets_source_idx ON density.runsheets (source, runid);
+GRANT SELECT, INSERT ON density.runsheets TO edservice;
+GRANT SELECT ON density.runsheets TO edviewer;
+
 CREATE TABLE density.surveys (
        id    int		  GENERATED ALWAYS AS IDENTITY,
        campaignid int		  NOT NULL,
        cmdrid	 int		  NOT NULL,
+       flags	 varchar(32)[]	  NOT NULL DEFAULT '{}',
+       -- the form responses' timestamp and email
+       submitted timestamptz,
+       respondent varchar(320),
+       -- the survey's date and the CMDR's notes from the sheet
+       surveydate date,
+       notes	 text,
+       rawtabid	 int,
+       -- where it was ingested from, the tab is the survey's name
+       spreadsheetid varchar(64),
+       tab	 varchar(128),
+       -- the configured source, NULL for the default one
+       source	 varchar(128),
+       -- the source disappeared, see density.retract
+       retracted timestamptz,
        FOREIGN KEY (campaignid) REFERENCES density.campaigns (id),
+       FOREIGN KEY (rawtabid) REFERENCES density.rawtabs(id),
        FOREIGN KEY (cmdrid) REFERENCES density.cmdrs(id),
        PRIMARY KEY (id)
 );
-GRANT SELECT, INSERT ON density.surveys TO edservice;
-GRANT SELECT ON density.surveys TO edviewer;
+CREATE INDEX surveys_source_idx ON density.surveys (spreadsheetid, tab);
+GRANT SELECT, INSERT, DELETE ON density.surveys TO edservice;
+GRANT UPDATE (retracted) ON density.surveys TO edservice;
+-- the respondents' emails are only for the service
+GRANT SELECT (id, campaignid, cmdrid, flags, submitted, surveydate, notes, rawtabid,
+      	      spreadsheetid, tab, source, retracted) ON density.surveys TO edviewer;
 
 CREATE TABLE density.surveypoints (
        id    int		  GENERATED ALWAYS AS IDENTITY,
        surveyid int	  NOT NULL,
        sysname	     varchar(64)  NOT NULL,
-       zsample	     int	  NOT NULL,
-       x	     real	  NOT NULL,
-       y	     real	  NOT NULL,
-       z	     real	  NOT NULL,
+       zsample	     real	  NOT NULL,
+       -- NULL coordinates: the system is not resolved yet, see backfill
+       x	     real,
+       y	     real,
+       z	     real,
+       -- edsm, cache or sheet, see densitysurvey.CoordSource*
+       coordsource   varchar(16),
        syscount	     int	  NOT NULL,
        maxdistance   real	  NOT NULL,
+       flags	     varchar(32)[] NOT NULL DEFAULT '{}',
+       resolveattempts int	  NOT NULL DEFAULT 0,
+       lastresolve   timestamptz,
        FOREIGN KEY (surveyid) REFERENCES density.surveys(id),
        PRIMARY KEY (id),
        UNIQUE (surveyid, zsample),
        UNIQUE (surveyid, sysname),
        CHECK (syscount >= 0 AND syscount <= 50),
-       CHECK (maxdistance > 0 AND maxdistance <= 20)
+       CHECK (maxdistance > 0 AND maxdistance <= 20),
+       CHECK ((x IS NULL) = (y IS NULL) AND (y IS NULL) = (z IS NULL)),
+       CHECK ((x IS NULL) = (coordsource IS NULL))
 );
-GRANT SELECT, INSERT ON density.surveypoints TO edservice;
+CREATE INDEX surveypoints_unresolved_idx ON density.surveypoints (lastresolve NULLS FIRST)
+       WHERE x IS NULL OR coordsource = 'sheet';
+GRANT SELECT, INSERT, DELETE ON density.surveypoints TO edservice;
+GRANT UPDATE (x, y, z, coordsource, flags, resolveattempts, lastresolve) ON density.surveypoints TO edservice;
 GRANT SELECT ON density.surveypoints TO edviewer;
@@ -5,9 +5,14 @@ SELECT sp.id, sp.surveyid, sp.sysname,
        greatest(least(sp.syscount, 50), 1) AS syscount,
        greatest(least(sp.maxdistance, 20), 1) AS maxdistance
 FROM density.surveypoints sp
+     JOIN density.surveys s ON s.id = sp.surveyid
+WHERE sp.x IS NOT NULL AND s.retracted IS NULL
 )
 SELECT a.*,
-       a.syscount/((4*pi()/3)*power(a.maxdistance, 3)) AS rho
+       a.syscount/((4*pi()/3)*power(a.maxdistance, 3)) AS rho,
+       -- signed log10 of the height, for the logarithmic surveys
+       CASE WHEN a.zsample = 0 THEN NULL
+       	    ELSE sign(a.zsample)*log(abs(a.zsample)::numeric) END AS logz
 FROM adjusted a
 ;
 GRANT SELECT ON density.v_surveypoints TO edservice;
@@ -24,9 +29,11 @@ SELECT sp.surveyid,
 FROM density.v_surveypoints sp
 GROUP BY sp.surveyid
 )
+-- the respondent is left out, the view is readable by edviewer
 SELECT cmdr.name AS cmdrname,
        c.name AS campaignname,
-       s.*,
+       s.id, s.campaignid, s.cmdrid, s.flags, s.submitted, s.surveydate, s.notes,
+       s.rawtabid, s.spreadsheetid, s.tab, s.source, s.retracted,
        sp.*
 FROM density.surveys s
      JOIN stats sp ON s.id = sp.surveyid
@@ -35,3 +42,53 @@ FROM density.surveys s
 ;
 GRANT SELECT ON density.v_surveys TO edservice;
 GRANT SELECT ON density.v_surveys TO edviewer;
+
+CREATE OR REPLACE VIEW density.v_unresolved AS
+SELECT sp.id, sp.surveyid, sp.sysname, sp.zsample, sp.coordsource,
+       sp.resolveattempts, sp.lastresolve
+FROM density.surveypoints sp
+     JOIN density.surveys s ON s.id = sp.surveyid
+WHERE (sp.x IS NULL OR sp.coordsource = 'sheet') AND s.retracted IS NULL
+;
+GRANT SELECT ON density.v_unresolved TO edservice;
+GRANT SELECT ON density.v_unresolved TO edviewer;
+
+-- Exponential disk fit of the density profile of each survey:
+-- rho(z) = rho0 * exp(-|z|/h), fitted as ln(rho) linear in |z|
+CREATE OR REPLACE VIEW density.v_surveyprofiles AS
+WITH fit AS (
+SELECT sp.surveyid,
+       count(*) AS npoints,
+       min(sp.zsample) AS zmin,
+       max(sp.zsample) AS zmax,
+       regr_slope(ln(sp.rho), abs(sp.zsample)) AS slope,
+       regr_intercept(ln(sp.rho), abs(sp.zsample)) AS intercept,
+       regr_r2(ln(sp.rho), abs(sp.zsample)) AS r2
+FROM density.v_surveypoints sp
+WHERE sp.rho > 0
+GROUP BY sp.surveyid
+)
+SELECT f.surveyid, c.name AS campaignname,
+       f.npoints, f.zmin, f.zmax,
+       exp(f.intercept) AS rho0,
+       CASE WHEN f.slope < 0 THEN -1/f.slope ELSE NULL END AS scaleheight,
+       f.r2
+FROM fit f
+     JOIN density.surveys s ON s.id = f.surveyid
+     JOIN density.campaigns c ON s.campaignid = c.id
+;
+GRANT SELECT ON density.v_surveyprofiles TO edservice;
+GRANT SELECT ON density.v_surveyprofiles TO edviewer;
+
+-- the surveys whose spreadsheet or tab disappeared, left out of the other
+-- views
+CREATE OR REPLACE VIEW density.v_retracted AS
+SELECT s.id, cmdr.name AS cmdrname, c.name AS campaignname,
+       s.spreadsheetid, s.tab, s.retracted
+FROM density.surveys s
+     JOIN density.campaigns c ON s.campaignid = c.id
+     JOIN density.cmdrs cmdr ON s.cmdrid = cmdr.id
+WHERE s.retracted IS NOT NULL
+;
+GRANT SELECT ON density.v_retracted TO edservice;
+GRANT SELECT ON density.v_retracted TO edviewer;